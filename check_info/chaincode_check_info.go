@@ -19,7 +19,8 @@ type Info struct {
 	Content     string
 	CompanyName string
 	City        string
-	Price       int
+	Category    string
+	Price       Amount
 	PublishTime time.Time
 }
 type User struct {
@@ -33,6 +34,13 @@ type User struct {
 	CompanyName string
 }
 
+//审核通过的商家申请
+type Merchant struct {
+	Account     string
+	CompanyName string
+	State       int
+}
+
 type InfoCheckChaincode struct {
 }
 
@@ -59,9 +67,17 @@ func (t *InfoCheckChaincode) check(stub shim.ChaincodeStubInterface, pubKey stri
 	if len(i.Title) == 0 || len(i.Content) == 0 || len(i.City) == 0 {
 		return shim.Error("Title,Content,City必须填写")
 	}
-	if i.Price < 0 {
+	if i.Price.Sign() < 0 {
 		return shim.Error("价格不能小于0")
 	}
+	if err = CheckAmount(&i.Price.Int); err != nil {
+		return shim.Error(err.Error())
+	}
+	//停用的账户不能发布信息
+	activeResponse := stub.InvokeChaincode("user", [][]byte{[]byte("checkActive"), []byte(pubKey)}, "")
+	if activeResponse.GetStatus() != shim.OK {
+		return activeResponse
+	}
 	userResponse := stub.InvokeChaincode("user", [][]byte{[]byte("get"), []byte(pubKey)}, "")
 	if userResponse.GetStatus() != shim.OK {
 		return userResponse
@@ -78,8 +94,23 @@ func (t *InfoCheckChaincode) check(stub shim.ChaincodeStubInterface, pubKey stri
 	if len(u.CompanyName) == 0 {
 		return shim.Error("非商家账号不能发布信息")
 	}
+	//只有审核通过的商家可以发布信息
+	merchantResponse := stub.InvokeChaincode("user", [][]byte{[]byte("checkMerchant"), []byte(pubKey)}, "")
+	if merchantResponse.GetStatus() != shim.OK {
+		return merchantResponse
+	}
+	var m Merchant
+	if err = json.Unmarshal(merchantResponse.GetPayload(), &m); err != nil {
+		return shim.Error("商家数据异常")
+	}
+	//商家需缴纳足额保证金
+	bondResponse := stub.InvokeChaincode("coin", [][]byte{[]byte("checkBond"), []byte(pubKey)}, "")
+	if bondResponse.GetStatus() != shim.OK {
+		return bondResponse
+	}
 
-	i.CompanyName = u.CompanyName
+	//使用审核通过的商家名称
+	i.CompanyName = m.CompanyName
 	i.PubKey = pubKey
 
 	tm, err := stub.GetTxTimestamp()
@@ -1,23 +1,50 @@
 package main
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"github.com/hyperledger/fabric/core/chaincode/shim"
 	pb "github.com/hyperledger/fabric/protos/peer"
+	"strings"
 )
 
-//['check','{"name":"Yan","Age":"18","ID":"43070212345767","Nickname":"成本","Phonenum":"1376890876"}']
-//'{"Function":"check","Args":["{\"Name\":\"Yan\",\"Age\":\"18\",\"ID\":\"43070212345767\",\"Nickname\":\"swf\",\"Phonenum\":\"1376890876\"}"]}'
-type User struct {
-	Nickname string
-	Name     string
-	Age      string
-	Phonenum string
-	ID       string
+//['check','{"Nickname":"成本"}'], transient: {"pii":"{\"Name\":\"Yan\",\"Age\":\"18\",\"ID\":\"43070212345767\",\"Phonenum\":\"1376890876\",\"Salt\":\"a8f3c1d92b7e4f60\"}"}
+//'{"Function":"check","Args":["{\"Nickname\":\"swf\"}"]}'
+
+//个人信息通过transient的该字段传入, 不写入交易参数
+const TRANSIENT_PII = "pii"
 
-	CompanyID   string
+//公开的用户信息, 个人信息只保存加盐哈希
+type User struct {
+	Nickname    string
 	CompanyName string
+	PIIHash     string
+
+	//旧版本公开的个人信息字段, 只用于拒绝明文提交
+	Name      string `json:",omitempty"`
+	Age       string `json:",omitempty"`
+	Phonenum  string `json:",omitempty"`
+	ID        string `json:",omitempty"`
+	CompanyID string `json:",omitempty"`
+}
+
+//个人信息, 保存在私有数据集合中, Salt由客户端随机生成
+type PII struct {
+	Name      string
+	Age       string
+	Phonenum  string
+	ID        string
+	CompanyID string
+	Salt      string
+}
+
+//个人信息哈希, sha256(Salt|Name|Age|Phonenum|ID|CompanyID)
+func (p *PII) hash() string {
+	data := strings.Join([]string{p.Salt, p.Name, p.Age, p.Phonenum, p.ID, p.CompanyID}, "|")
+	sum := sha256.Sum256([]byte(data))
+	return hex.EncodeToString(sum[:])
 }
 
 type UserCheckChaincode struct {
@@ -32,10 +59,19 @@ func (t *UserCheckChaincode) Invoke(stub shim.ChaincodeStubInterface) pb.Respons
 	function, args := stub.GetFunctionAndParameters()
 	if function == "check" {
 		return t.check(stub, args[0])
+	} else if function == "checkPatch" {
+		if len(args) != 2 {
+			return shim.Error("Incorrect num of args, excepting 2")
+		}
+		return t.checkPatch(stub, args[0], args[1])
 	}
 	return shim.Success([]byte("error fuction"))
 }
 
+/**
+校验公开信息及transient中的个人信息
+返回写入公开账本的用户信息
+*/
 func (t *UserCheckChaincode) check(stub shim.ChaincodeStubInterface, user_str string) pb.Response {
 	u, err := jsonToUser(user_str)
 	if err != nil {
@@ -43,16 +79,77 @@ func (t *UserCheckChaincode) check(stub shim.ChaincodeStubInterface, user_str st
 		fmt.Println(error_str)
 		return shim.Error(error_str)
 	}
-	if len(u.Name) == 0 || len(u.Age) == 0 || len(u.Phonenum) == 0 || len(u.ID) == 0 || len(u.Nickname) == 0 {
+	if len(u.Name) > 0 || len(u.Age) > 0 || len(u.Phonenum) > 0 || len(u.ID) > 0 || len(u.CompanyID) > 0 {
+		return shim.Error("Name,Age,Phonenum,ID,CompanyID需通过transient传入")
+	}
+	transient, err := stub.GetTransient()
+	if err != nil {
+		return shim.Error("get transient error")
+	}
+	pii_str, ok := transient[TRANSIENT_PII]
+	if !ok {
+		return shim.Error("缺少个人信息")
+	}
+	var p PII
+	if err = json.Unmarshal(pii_str, &p); err != nil {
+		return shim.Error(fmt.Sprintf("pii json error: %s", err))
+	}
+	if len(p.Name) == 0 || len(p.Age) == 0 || len(p.Phonenum) == 0 || len(p.ID) == 0 || len(u.Nickname) == 0 {
 		return shim.Error("Name,Age,Phonenum,ID,Nickname必须填写")
 	}
-	if (len(u.CompanyID) == 0 && len(u.CompanyName) > 0) || (len(u.CompanyID) > 0 && len(u.CompanyName) == 0) {
+	if len(p.Salt) < 16 {
+		return shim.Error("Salt至少16位")
+	}
+	if (len(p.CompanyID) == 0 && len(u.CompanyName) > 0) || (len(p.CompanyID) > 0 && len(u.CompanyName) == 0) {
 		return shim.Error("商家信息不完整,CompanyID、CompanyName必须填写")
 	}
+	u.PIIHash = p.hash()
 
 	return shim.Success(u.toString())
 }
 
+//允许通过patch修改的公开字段, 个人信息需通过set重新提交
+var patchFields = map[string]bool{"Nickname": true, "CompanyName": true}
+
+/**
+校验部分更新, 返回合并后的用户信息
+商家名称只能修改, 开通或取消商家需通过set提交CompanyID
+*/
+func (t *UserCheckChaincode) checkPatch(stub shim.ChaincodeStubInterface, old_str, patch_str string) pb.Response {
+	var merged map[string]interface{}
+	if err := json.Unmarshal([]byte(old_str), &merged); err != nil {
+		return shim.Error(fmt.Sprintf("user json error: %s", err))
+	}
+	var patch map[string]string
+	if err := json.Unmarshal([]byte(patch_str), &patch); err != nil {
+		return shim.Error(fmt.Sprintf("patch json error: %s", err))
+	}
+	if len(patch) == 0 {
+		return shim.Error("patch不能为空")
+	}
+	old, err := jsonToUser(old_str)
+	if err != nil {
+		return shim.Error("用户数据异常")
+	}
+	for field, value := range patch {
+		if !patchFields[field] {
+			return shim.Error(fmt.Sprintf("字段不允许修改: %s", field))
+		}
+		merged[field] = value
+	}
+	if nickname, ok := patch["Nickname"]; ok && len(nickname) == 0 {
+		return shim.Error("Nickname必须填写")
+	}
+	if companyName, ok := patch["CompanyName"]; ok && (len(companyName) == 0 || len(old.CompanyName) == 0) {
+		return shim.Error("开通或取消商家需通过set提交CompanyID")
+	}
+	data, err := json.Marshal(merged)
+	if err != nil {
+		return shim.Error("json error")
+	}
+	return shim.Success(data)
+}
+
 func (u *User) toString() []byte {
 	if data, err := json.Marshal(u); err == nil {
 		return data
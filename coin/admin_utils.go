@@ -0,0 +1,87 @@
+package main
+
+import (
+	"github.com/hyperledger/fabric/core/chaincode/lib/cid"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+const KEY_ADMIN_MSP = "coin_admin_msp"
+const DEFAULT_ADMIN_MSP = "Org1MSP"
+
+//管理员证书需包含该属性且值为true
+const ADMIN_ATTR = "gravity.admin"
+
+/**
+校验交易发起者是否为管理员
+发起者需属于管理员MSP, 且证书带有管理员属性
+*/
+func isAdmin(stub shim.ChaincodeStubInterface) bool {
+	mspID, err := cid.GetMSPID(stub)
+	if err != nil {
+		return false
+	}
+	adminMSP, err := stub.GetState(KEY_ADMIN_MSP)
+	if err != nil {
+		return false
+	}
+	if len(adminMSP) == 0 {
+		adminMSP = []byte(DEFAULT_ADMIN_MSP)
+	}
+	if mspID != string(adminMSP) {
+		return false
+	}
+	return cid.AssertAttributeValue(stub, ADMIN_ATTR, "true") == nil
+}
+
+//币种发行方证书需包含该属性且值为true
+const ISSUER_ATTR = "gravity.issuer"
+
+/**
+校验交易发起者是否可以发行该币种
+管理员可以发行所有币种, 币种发行方MSP中带有发行属性的证书可以发行该币种
+*/
+func canIssue(stub shim.ChaincodeStubInterface, tk *Token) bool {
+	if isAdmin(stub) {
+		return true
+	}
+	if tk.Symbol == DEFAULT_TOKEN {
+		return false
+	}
+	mspID, err := cid.GetMSPID(stub)
+	if err != nil || mspID != tk.Issuer {
+		return false
+	}
+	return cid.AssertAttributeValue(stub, ISSUER_ATTR, "true") == nil
+}
+
+//仲裁员证书需包含该属性且值为true
+const ARBITRATOR_ATTR = "gravity.arbitrator"
+const KEY_ARBITRATOR_MSP = "coin_arbitrator_msp"
+
+/**
+校验交易发起者是否为仲裁员
+发起者需属于仲裁员MSP(未配置时为管理员MSP), 且证书带有仲裁员属性
+*/
+func isArbitrator(stub shim.ChaincodeStubInterface) bool {
+	mspID, err := cid.GetMSPID(stub)
+	if err != nil {
+		return false
+	}
+	arbitratorMSP, err := stub.GetState(KEY_ARBITRATOR_MSP)
+	if err != nil {
+		return false
+	}
+	if len(arbitratorMSP) == 0 {
+		arbitratorMSP, err = stub.GetState(KEY_ADMIN_MSP)
+		if err != nil {
+			return false
+		}
+	}
+	if len(arbitratorMSP) == 0 {
+		arbitratorMSP = []byte(DEFAULT_ADMIN_MSP)
+	}
+	if mspID != string(arbitratorMSP) {
+		return false
+	}
+	return cid.AssertAttributeValue(stub, ARBITRATOR_ATTR, "true") == nil
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"fmt"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+	"math/big"
+	"strings"
+)
+
+const PRE_KEY_ALLOWANCE = "coin_allowance"
+
+const OP_APPROVE = "approve"
+
+/**
+授权, owner允许spender合约动用自己最多amount的币, 重复授权覆盖原额度
+签名内容为 approve|spender|amount|nonce, 非默认币种为 approve|spender|amount|nonce|symbol
+*/
+func (t *CoinChaincode) approve(stub shim.ChaincodeStubInterface, owner, spender, amount_str, nonce, sign, symbol string) pb.Response {
+	//签名信息校验
+	payload := []string{OP_APPROVE, spender, amount_str, nonce}
+	if symbol != DEFAULT_TOKEN {
+		payload = append(payload, symbol)
+	}
+	if !verifyAccount(stub, owner, strings.Join(payload, "|"), sign) {
+		return shim.Error("签名验证失败")
+	}
+	amount, err := ParseAmount(amount_str)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if len(spender) == 0 {
+		return shim.Error("spender必须填写")
+	}
+	//直接调用coin合约时调用方为coin, 不能授权给coin, 否则任何人都可以动用该额度
+	if spender == COIN_CHAINCODE {
+		return shim.Error("spender不能为coin")
+	}
+	if _, err = mustGetToken(stub, symbol); err != nil {
+		return shim.Error(err.Error())
+	}
+	checkOwner := checkUser(stub, owner)
+	if checkOwner.GetStatus() != shim.OK {
+		return shim.Error(fmt.Sprintf("owner: %s", checkOwner.GetMessage()))
+	}
+	//防止重放
+	err = useNonce(stub, owner, nonce)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	key, err := allowanceKey(stub, owner, spender, symbol)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if err = putAmountState(stub, key, amount); err != nil {
+		return shim.Error("put fail")
+	}
+	return shim.Success([]byte("ok"))
+}
+
+//查询owner授权给spender的剩余额度
+func (t *CoinChaincode) allowance(stub shim.ChaincodeStubInterface, owner, spender, symbol string) pb.Response {
+	key, err := allowanceKey(stub, owner, spender, symbol)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	amount, err := getAmountState(stub, key)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success([]byte(FormatAmount(amount)))
+}
+
+//调用方合约在授权额度内将owner的币转给to
+func (t *CoinChaincode) transferFrom(stub shim.ChaincodeStubInterface, owner, to string, amount *big.Int, symbol string) pb.Response {
+	if amount.Sign() <= 0 {
+		return shim.Error("amount must be positive")
+	}
+	if owner == to {
+		return shim.Error("from and to must be different")
+	}
+	token, err := mustGetToken(stub, symbol)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if err = token.checkPrecision(amount); err != nil {
+		return shim.Error(err.Error())
+	}
+	checkFrom := checkUser(stub, owner)
+	if checkFrom.GetStatus() != shim.OK {
+		return shim.Error(fmt.Sprintf("from: %s", checkFrom.GetMessage()))
+	}
+	checkTo := checkUser(stub, to)
+	if checkTo.GetStatus() != shim.OK {
+		return shim.Error(fmt.Sprintf("to: %s", checkTo.GetMessage()))
+	}
+	if err = spendAllowance(stub, owner, symbol, amount); err != nil {
+		return shim.Error(err.Error())
+	}
+	//校验限额
+	if err = useSpendLimit(stub, owner, symbol, amount); err != nil {
+		return shim.Error(err.Error())
+	}
+	if err = moveCoin(stub, OP_TRANSFER, symbol, owner, to, amount); err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success([]byte("ok"))
+}
+
+//调用方合约在授权额度内冻结owner的币
+func (t *CoinChaincode) freezeFrom(stub shim.ChaincodeStubInterface, owner string, amount *big.Int, holdID, symbol string) pb.Response {
+	if amount.Sign() <= 0 {
+		return shim.Error("amount must be positive")
+	}
+	if err := spendAllowance(stub, owner, symbol, amount); err != nil {
+		return shim.Error(err.Error())
+	}
+	return t.holdCoin(stub, owner, amount, holdID, symbol, true)
+}
+
+//扣减调用方合约的授权额度
+func spendAllowance(stub shim.ChaincodeStubInterface, owner, symbol string, amount *big.Int) error {
+	spender, err := getCallerChaincode(stub)
+	if err != nil {
+		return fmt.Errorf("获取调用方合约失败: %s", err)
+	}
+	if spender == COIN_CHAINCODE {
+		return fmt.Errorf("不能直接调用")
+	}
+	key, err := allowanceKey(stub, owner, spender, symbol)
+	if err != nil {
+		return err
+	}
+	allowed, err := getAmountState(stub, key)
+	if err != nil {
+		return err
+	}
+	left, err := SubAmount(allowed, amount)
+	if err != nil {
+		return fmt.Errorf("allowance not enough")
+	}
+	return putAmountState(stub, key, left)
+}
+
+func allowanceKey(stub shim.ChaincodeStubInterface, owner, spender, symbol string) (string, error) {
+	return stub.CreateCompositeKey(PRE_KEY_ALLOWANCE, []string{owner, spender, symbol})
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+	"math/big"
+	"strings"
+	"time"
+)
+
+const PRE_KEY_BATCH = "coin_batch"
+
+const OP_BATCH = "batch"
+
+//单批次最多收款人数
+const MAX_BATCH_SIZE = 500
+
+type BatchItem struct {
+	To     string
+	Amount Amount
+}
+
+//批量转账记录, ID为交易ID
+type Batch struct {
+	ID    string
+	From  string
+	Token string
+	Total Amount
+	Count int
+	Items []BatchItem
+	Time  time.Time
+}
+
+func (b *Batch) toString() []byte {
+	if data, err := json.Marshal(b); err == nil {
+		return data
+	}
+	return []byte("err")
+}
+
+/**
+批量转账, 所有收款全部成功或全部失败
+batch_str格式为 [{"To":"pubkey","Amount":"10.00"}, ...], 收款人不能重复
+签名内容为 batch|nonce|batch_str, 非默认币种为 batch|nonce|batch_str|symbol, 由Verify对整个签名内容做sha256摘要
+nonce放在batch_str之前, 避免与json中的内容混淆
+*/
+func (t *CoinChaincode) batchTransfer(stub shim.ChaincodeStubInterface, from, batch_str, nonce, sign, symbol string) pb.Response {
+	//签名信息校验
+	payload := []string{OP_BATCH, nonce, batch_str}
+	if symbol != DEFAULT_TOKEN {
+		payload = append(payload, symbol)
+	}
+	if !verifyAccount(stub, from, strings.Join(payload, "|"), sign) {
+		return shim.Error("签名验证失败")
+	}
+	var items []BatchItem
+	err := json.Unmarshal([]byte(batch_str), &items)
+	if err != nil {
+		return shim.Error(fmt.Sprintf("batch json error: %s", err))
+	}
+	if len(items) == 0 || len(items) > MAX_BATCH_SIZE {
+		return shim.Error(fmt.Sprintf("收款人数必须在1到%d之间", MAX_BATCH_SIZE))
+	}
+	token, err := mustGetToken(stub, symbol)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	checkFrom := checkUser(stub, from)
+	if checkFrom.GetStatus() != shim.OK {
+		return shim.Error(fmt.Sprintf("from: %s", checkFrom.GetMessage()))
+	}
+	//校验收款人及金额
+	total := new(big.Int)
+	seen := make(map[string]bool)
+	for _, item := range items {
+		if item.To == from {
+			return shim.Error("from and to must be different")
+		}
+		if seen[item.To] {
+			return shim.Error(fmt.Sprintf("收款人重复: %s", item.To))
+		}
+		seen[item.To] = true
+		if item.Amount.Sign() <= 0 {
+			return shim.Error("amount must be positive")
+		}
+		if err = token.checkPrecision(&item.Amount.Int); err != nil {
+			return shim.Error(err.Error())
+		}
+		checkTo := checkUser(stub, item.To)
+		if checkTo.GetStatus() != shim.OK {
+			return shim.Error(fmt.Sprintf("to %s: %s", item.To, checkTo.GetMessage()))
+		}
+		total, err = AddAmount(total, &item.Amount.Int)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+	}
+	//防止重放
+	err = useNonce(stub, from, nonce)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	//校验限额
+	if err = useSpendLimit(stub, from, symbol, total); err != nil {
+		return shim.Error(err.Error())
+	}
+	//扣减付款人余额, 同一交易内不能重复读写同一账户, 因此按总额一次扣减
+	balance, err := getAmountState(stub, balanceKey(from, symbol))
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	newBalance, err := SubAmount(balance, total)
+	if err != nil {
+		return shim.Error("balance not enough")
+	}
+	if err = putAmountState(stub, balanceKey(from, symbol), newBalance); err != nil {
+		return shim.Error("put fail")
+	}
+	for _, item := range items {
+		if err = creditAccount(stub, symbol, item.To, &item.Amount.Int); err != nil {
+			return shim.Error(err.Error())
+		}
+		if err = writeJournal(stub, OP_BATCH, symbol, from, item.To, &item.Amount.Int, stub.GetTxID()); err != nil {
+			return shim.Error(err.Error())
+		}
+	}
+	//批次记录
+	tm, err := stub.GetTxTimestamp()
+	if err != nil {
+		return shim.Error("get timestamp fail")
+	}
+	batch := &Batch{ID: stub.GetTxID(), From: from, Token: symbol, Count: len(items), Items: items, Time: time.Unix(tm.Seconds, 0)}
+	batch.Total.Set(total)
+	key, err := stub.CreateCompositeKey(PRE_KEY_BATCH, []string{batch.ID})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if err = stub.PutState(key, batch.toString()); err != nil {
+		return shim.Error("put fail")
+	}
+	return shim.Success([]byte(batch.ID))
+}
+
+//查询批量转账记录
+func (t *CoinChaincode) getBatch(stub shim.ChaincodeStubInterface, batchID string) pb.Response {
+	key, err := stub.CreateCompositeKey(PRE_KEY_BATCH, []string{batchID})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	b, err := stub.GetState(key)
+	if err != nil {
+		return shim.Error("get data error")
+	}
+	if len(b) == 0 {
+		return shim.Error("batch not find")
+	}
+	return shim.Success(b)
+}
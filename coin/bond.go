@@ -0,0 +1,332 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+	"math/big"
+	"strconv"
+	"time"
+)
+
+const PRE_KEY_BOND = "coin_bond"
+const KEY_BOND_COOLDOWN = "coin_bond_cooldown"
+const KEY_MIN_BOND = "coin_min_bond"
+
+//保证金托管账户
+const ACCOUNT_BOND = "@bond"
+
+//默认保证金解锁等待期7天
+const DEFAULT_BOND_COOLDOWN = 7 * 24 * 3600
+
+//默认发布信息所需的最低保证金
+const DEFAULT_MIN_BOND = "100"
+
+const BOND_ACTIVE = 1
+const BOND_UNLOCKING = 2
+
+const OP_BOND = "bond"
+const OP_UNBOND = "unbond"
+const OP_SLASH = "slash"
+
+//商家保证金, 申请解锁后需等待到UnlockTime才能取回
+type Bond struct {
+	Account    string
+	Amount     Amount
+	Slashed    Amount
+	State      int
+	UnlockTime time.Time
+	UpdateTime time.Time
+}
+
+func (b *Bond) toString() []byte {
+	if data, err := json.Marshal(b); err == nil {
+		return data
+	}
+	return []byte("err")
+}
+
+func getBond(stub shim.ChaincodeStubInterface, pubKey string) (*Bond, error) {
+	key, err := stub.CreateCompositeKey(PRE_KEY_BOND, []string{pubKey})
+	if err != nil {
+		return nil, err
+	}
+	b, err := stub.GetState(key)
+	if err != nil {
+		return nil, fmt.Errorf("get data error: %s", err)
+	}
+	if len(b) == 0 {
+		return nil, nil
+	}
+	var bond Bond
+	err = json.Unmarshal(b, &bond)
+	if err != nil {
+		return nil, fmt.Errorf("bond json error: %s", err)
+	}
+	return &bond, nil
+}
+
+func putBond(stub shim.ChaincodeStubInterface, bond *Bond) error {
+	key, err := stub.CreateCompositeKey(PRE_KEY_BOND, []string{bond.Account})
+	if err != nil {
+		return err
+	}
+	if bond.Amount.Sign() == 0 && bond.State != BOND_ACTIVE {
+		return stub.DelState(key)
+	}
+	return stub.PutState(key, bond.toString())
+}
+
+/**
+缴纳保证金, 从余额转入保证金, 可多次追加
+签名内容为 bond|amount|nonce
+*/
+func (t *CoinChaincode) lockBond(stub shim.ChaincodeStubInterface, pubKey, amount_str, nonce, sign string) pb.Response {
+	if err := verifySigned(stub, pubKey, sign, nonce, OP_BOND, amount_str); err != nil {
+		return shim.Error(err.Error())
+	}
+	amount, err := ParseAmount(amount_str)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if amount.Sign() <= 0 {
+		return shim.Error("amount must be positive")
+	}
+	checkRs := checkUser(stub, pubKey)
+	if checkRs.GetStatus() != shim.OK {
+		return checkRs
+	}
+	bond, err := getBond(stub, pubKey)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if bond == nil {
+		bond = &Bond{Account: pubKey, State: BOND_ACTIVE}
+	}
+	if bond.State != BOND_ACTIVE {
+		return shim.Error("保证金解锁中, 不能追加")
+	}
+	balance, err := getAmountState(stub, balanceKey(pubKey, DEFAULT_TOKEN))
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	newBalance, err := SubAmount(balance, amount)
+	if err != nil {
+		return shim.Error("balance not enough")
+	}
+	newBond, err := AddAmount(&bond.Amount.Int, amount)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	tm, err := stub.GetTxTimestamp()
+	if err != nil {
+		return shim.Error("get timestamp fail")
+	}
+	bond.Amount.Set(newBond)
+	bond.UpdateTime = time.Unix(tm.Seconds, 0)
+
+	//更新数据
+	if err = putAmountState(stub, balanceKey(pubKey, DEFAULT_TOKEN), newBalance); err != nil {
+		return shim.Error("put fail")
+	}
+	if err = putBond(stub, bond); err != nil {
+		return shim.Error("put fail")
+	}
+	if err = writeJournal(stub, OP_BOND, DEFAULT_TOKEN, pubKey, ACCOUNT_BOND, amount, ""); err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success([]byte("ok"))
+}
+
+/**
+申请解锁保证金, 解锁期间不能发布信息
+签名内容为 unbond|nonce
+*/
+func (t *CoinChaincode) requestUnbond(stub shim.ChaincodeStubInterface, pubKey, nonce, sign string) pb.Response {
+	if err := verifySigned(stub, pubKey, sign, nonce, OP_UNBOND); err != nil {
+		return shim.Error(err.Error())
+	}
+	//停用的账户不能取回保证金, 保留给仲裁罚没
+	checkRs := checkUser(stub, pubKey)
+	if checkRs.GetStatus() != shim.OK {
+		return checkRs
+	}
+	bond, err := getBond(stub, pubKey)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if bond == nil || bond.State != BOND_ACTIVE {
+		return shim.Error("没有可解锁的保证金")
+	}
+	cooldown, err := getIntState(stub, KEY_BOND_COOLDOWN)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if cooldown <= 0 {
+		cooldown = DEFAULT_BOND_COOLDOWN
+	}
+	tm, err := stub.GetTxTimestamp()
+	if err != nil {
+		return shim.Error("get timestamp fail")
+	}
+	bond.State = BOND_UNLOCKING
+	bond.UpdateTime = time.Unix(tm.Seconds, 0)
+	bond.UnlockTime = bond.UpdateTime.Add(time.Duration(cooldown) * time.Second)
+	if err = putBond(stub, bond); err != nil {
+		return shim.Error("put fail")
+	}
+	return shim.Success(bond.toString())
+}
+
+/**
+等待期结束后取回全部保证金
+签名内容为 withdrawBond|nonce
+*/
+func (t *CoinChaincode) withdrawBond(stub shim.ChaincodeStubInterface, pubKey, nonce, sign string) pb.Response {
+	if err := verifySigned(stub, pubKey, sign, nonce, "withdrawBond"); err != nil {
+		return shim.Error(err.Error())
+	}
+	//停用的账户不能取回保证金, 保留给仲裁罚没
+	checkRs := checkUser(stub, pubKey)
+	if checkRs.GetStatus() != shim.OK {
+		return checkRs
+	}
+	bond, err := getBond(stub, pubKey)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if bond == nil || bond.State != BOND_UNLOCKING {
+		return shim.Error("保证金未申请解锁")
+	}
+	tm, err := stub.GetTxTimestamp()
+	if err != nil {
+		return shim.Error("get timestamp fail")
+	}
+	now := time.Unix(tm.Seconds, 0)
+	if now.Before(bond.UnlockTime) {
+		return shim.Error(fmt.Sprintf("保证金%s后才能取回", bond.UnlockTime.Format(time.RFC3339)))
+	}
+	amount := new(big.Int).Set(&bond.Amount.Int)
+	bond.Amount.SetInt64(0)
+	bond.UpdateTime = now
+
+	//更新数据
+	if err = creditAccount(stub, DEFAULT_TOKEN, pubKey, amount); err != nil {
+		return shim.Error(err.Error())
+	}
+	if err = putBond(stub, bond); err != nil {
+		return shim.Error("put fail")
+	}
+	if err = writeJournal(stub, OP_UNBOND, DEFAULT_TOKEN, ACCOUNT_BOND, pubKey, amount, ""); err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success([]byte("ok"))
+}
+
+//罚没商家保证金赔付给消费者, 只允许仲裁员操作
+func (t *CoinChaincode) slashBond(stub shim.ChaincodeStubInterface, merchant, consumer string, amount *big.Int, reason string) pb.Response {
+	if !isArbitrator(stub) {
+		return shim.Error("没有仲裁权限")
+	}
+	if amount.Sign() <= 0 {
+		return shim.Error("amount must be positive")
+	}
+	if merchant == consumer {
+		return shim.Error("merchant and consumer must be different")
+	}
+	checkTo := checkUser(stub, consumer)
+	if checkTo.GetStatus() != shim.OK {
+		return shim.Error(fmt.Sprintf("consumer: %s", checkTo.GetMessage()))
+	}
+	bond, err := getBond(stub, merchant)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if bond == nil {
+		return shim.Error("bond not find")
+	}
+	left, err := SubAmount(&bond.Amount.Int, amount)
+	if err != nil {
+		return shim.Error("bond not enough")
+	}
+	slashed, err := AddAmount(&bond.Slashed.Int, amount)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	tm, err := stub.GetTxTimestamp()
+	if err != nil {
+		return shim.Error("get timestamp fail")
+	}
+	bond.Amount.Set(left)
+	bond.Slashed.Set(slashed)
+	bond.UpdateTime = time.Unix(tm.Seconds, 0)
+
+	//更新数据
+	if err = creditAccount(stub, DEFAULT_TOKEN, consumer, amount); err != nil {
+		return shim.Error(err.Error())
+	}
+	if err = putBond(stub, bond); err != nil {
+		return shim.Error("put fail")
+	}
+	if err = writeJournal(stub, OP_SLASH, DEFAULT_TOKEN, ACCOUNT_BOND, consumer, amount, merchant+"|"+reason); err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success([]byte("ok"))
+}
+
+//查询保证金
+func (t *CoinChaincode) getBond(stub shim.ChaincodeStubInterface, pubKey string) pb.Response {
+	bond, err := getBond(stub, pubKey)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if bond == nil {
+		return shim.Success(nil)
+	}
+	return shim.Success(bond.toString())
+}
+
+//校验商家是否有足额且未申请解锁的保证金, 供check_info调用
+func (t *CoinChaincode) checkBond(stub shim.ChaincodeStubInterface, pubKey string) pb.Response {
+	minBond, err := getMinBond(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	bond, err := getBond(stub, pubKey)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if bond == nil || bond.State != BOND_ACTIVE || bond.Amount.Cmp(minBond) < 0 {
+		return shim.Error(fmt.Sprintf("保证金不足%s", FormatAmount(minBond)))
+	}
+	return shim.Success([]byte("ok"))
+}
+
+//设置保证金参数, 只允许管理员操作
+func (t *CoinChaincode) setBondConfig(stub shim.ChaincodeStubInterface, minBond *big.Int, cooldown int) pb.Response {
+	if !isAdmin(stub) {
+		return shim.Error("没有管理员权限")
+	}
+	if cooldown <= 0 {
+		return shim.Error("cooldown must be positive")
+	}
+	if err := putAmountState(stub, KEY_MIN_BOND, minBond); err != nil {
+		return shim.Error("put fail")
+	}
+	if err := stub.PutState(KEY_BOND_COOLDOWN, []byte(strconv.Itoa(cooldown))); err != nil {
+		return shim.Error("put fail")
+	}
+	return shim.Success([]byte("ok"))
+}
+
+func getMinBond(stub shim.ChaincodeStubInterface) (*big.Int, error) {
+	b, err := stub.GetState(KEY_MIN_BOND)
+	if err != nil {
+		return nil, fmt.Errorf("get data error: %s", err)
+	}
+	if len(b) == 0 {
+		return ParseAmount(DEFAULT_MIN_BOND)
+	}
+	return ParseAmount(string(b))
+}
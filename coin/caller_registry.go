@@ -0,0 +1,91 @@
+package main
+
+import (
+	"fmt"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+const PRE_KEY_TRUSTED = "coin_trusted"
+const COIN_CHAINCODE = "coin"
+
+//只能由受信任合约触发的操作及默认受信任的合约
+var privilegedOps = map[string][]string{
+	OP_ISSUE:   {USER_CHAINCODE},
+	OP_FREEZE:  {TRADE_CHAINCODE},
+	OP_CONFIRM: {TRADE_CHAINCODE},
+	OP_RELEASE: {TRADE_CHAINCODE},
+}
+
+//写入默认受信任的合约, 已配置过的不覆盖
+func initTrustedCallers(stub shim.ChaincodeStubInterface) error {
+	for op, callers := range privilegedOps {
+		for _, caller := range callers {
+			key, err := stub.CreateCompositeKey(PRE_KEY_TRUSTED, []string{op, caller})
+			if err != nil {
+				return err
+			}
+			b, err := stub.GetState(key)
+			if err != nil {
+				return err
+			}
+			if len(b) > 0 {
+				continue
+			}
+			if err = stub.PutState(key, []byte("true")); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+/**
+校验调用方合约可以执行该操作
+直接调用coin合约的请求一律拒绝
+*/
+func checkCaller(stub shim.ChaincodeStubInterface, op string) error {
+	caller, err := getCallerChaincode(stub)
+	if err != nil {
+		return fmt.Errorf("获取调用方合约失败: %s", err)
+	}
+	if caller == COIN_CHAINCODE {
+		return fmt.Errorf("%s不允许直接调用", op)
+	}
+	key, err := stub.CreateCompositeKey(PRE_KEY_TRUSTED, []string{op, caller})
+	if err != nil {
+		return err
+	}
+	b, err := stub.GetState(key)
+	if err != nil {
+		return fmt.Errorf("get data error: %s", err)
+	}
+	if string(b) != "true" {
+		return fmt.Errorf("%s合约没有%s权限", caller, op)
+	}
+	return nil
+}
+
+//设置受信任合约, trusted为true或false, 只允许管理员操作
+func (t *CoinChaincode) setTrustedCaller(stub shim.ChaincodeStubInterface, op, caller, trusted string) pb.Response {
+	if !isAdmin(stub) {
+		return shim.Error("没有管理员权限")
+	}
+	if _, ok := privilegedOps[op]; !ok {
+		return shim.Error(fmt.Sprintf("unknown op: %s", op))
+	}
+	if len(caller) == 0 || caller == COIN_CHAINCODE {
+		return shim.Error("caller参数异常")
+	}
+	if trusted != "true" && trusted != "false" {
+		return shim.Error("trusted必须为true或false")
+	}
+	key, err := stub.CreateCompositeKey(PRE_KEY_TRUSTED, []string{op, caller})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if err = stub.PutState(key, []byte(trusted)); err != nil {
+		return shim.Error("put fail")
+	}
+	return shim.Success([]byte("ok"))
+}
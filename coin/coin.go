@@ -1,173 +1,1013 @@
 package main
 
 import (
+	"encoding/json"
 	"fmt"
 	"github.com/hyperledger/fabric/core/chaincode/shim"
 	pb "github.com/hyperledger/fabric/protos/peer"
+	"math/big"
 	"strconv"
+	"strings"
+	"time"
 )
 
 const SUFFIX_COIN = "_coin"
-const SUFFIX_FREEZE = "_coin_freeze"
+const SUFFIX_NONCE = "_coin_nonce"
+const TRADE_CHAINCODE = "trade"
+const USER_CHAINCODE = "user"
+const KEY_MAX_SUPPLY = "coin_max_supply"
+const KEY_TOTAL_SUPPLY = "coin_total_supply"
 
 //test case
 //peer chaincode query -C mychannel -n coin -c '{"Args":["get","Yanweiqing"]}'
+//peer chaincode invoke -C mychannel -n coin -c '{"Args":["transfer","fromPubkey","toPubkey","10","1","sign"]}'
+//peer chaincode instantiate -C mychannel -n coin -v 1.0 -c '{"Args":["init","Org1MSP","100000000"]}'
+//peer chaincode invoke -C mychannel -n coin -c '{"Args":["seedSupply"]}'
+//peer chaincode invoke -C mychannel -n coin -c '{"Args":["pruneHolds","pubkey1"]}'
+//peer chaincode invoke -C mychannel -n coin -c '{"Args":["createToken","CREDIT","0","1000000","Org1MSP"]}'
+//peer chaincode query -C mychannel -n coin -c '{"Args":["get","Yanweiqing","CREDIT"]}'
+//peer chaincode invoke -C mychannel -n coin -c '{"Args":["approve","ownerPubkey","trade","500","2","sign"]}'
+//peer chaincode invoke -C mychannel -n coin -c '{"Args":["deposit","oraclePubkey","{\"ID\":\"pay001\",\"Account\":\"pubkey1\",\"Amount\":\"100\"}","sign"]}'
+//peer chaincode invoke -C mychannel -n coin -c '{"Args":["batchTransfer","fromPubkey","[{\"To\":\"pubkey1\",\"Amount\":\"10\"},{\"To\":\"pubkey2\",\"Amount\":\"5.5\"}]","3","sign"]}'
 
 type CoinChaincode struct {
 }
 
+//初始化参数: [管理员MSP, 发行上限, 仲裁员MSP], 不传时保留原有配置
 func (t *CoinChaincode) Init(stub shim.ChaincodeStubInterface) pb.Response {
-
+	_, args := stub.GetFunctionAndParameters()
+	if len(args) > 0 && len(args[0]) > 0 {
+		err := stub.PutState(KEY_ADMIN_MSP, []byte(args[0]))
+		if err != nil {
+			return shim.Error("put fail")
+		}
+	}
+	if len(args) > 1 {
+		maxSupply, err := ParseAmount(args[1])
+		if err != nil {
+			return shim.Error("max supply参数异常")
+		}
+		if err = putAmountState(stub, KEY_MAX_SUPPLY, maxSupply); err != nil {
+			return shim.Error("put fail")
+		}
+	}
+	if len(args) > 2 && len(args[2]) > 0 {
+		err := stub.PutState(KEY_ARBITRATOR_MSP, []byte(args[2]))
+		if err != nil {
+			return shim.Error("put fail")
+		}
+	}
+	if err := initTrustedCallers(stub); err != nil {
+		return shim.Error(fmt.Sprintf("init trusted callers fail: %s", err))
+	}
 	return shim.Success([]byte("ok"))
 }
 
+//调用成功后发送本次调用的资金变动事件
 func (t *CoinChaincode) Invoke(stub shim.ChaincodeStubInterface) pb.Response {
+	function, _ := stub.GetFunctionAndParameters()
+	es := &eventStub{ChaincodeStubInterface: stub}
+	rs := t.invoke(es)
+	if rs.GetStatus() != shim.OK {
+		return rs
+	}
+	if err := es.flush(function); err != nil {
+		return shim.Error(fmt.Sprintf("set event fail: %s", err))
+	}
+	return rs
+}
+
+func (t *CoinChaincode) invoke(stub shim.ChaincodeStubInterface) pb.Response {
 	function, args := stub.GetFunctionAndParameters()
 	if function == "issue" {
+		if len(args) != 2 && len(args) != 3 {
+			return shim.Error("Incorrect num of args, excepting 2 or 3")
+		}
+		amount, err := ParseAmount(args[1])
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		return t.issue(stub, args[0], amount, tokenArg(args, 2))
+	} else if function == "freeze" {
+		if len(args) != 3 && len(args) != 4 {
+			return shim.Error("Incorrect num of args, excepting 3 or 4")
+		}
+		amount, err := ParseAmount(args[1])
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		return t.freeze(stub, args[0], amount, args[2], tokenArg(args, 3))
+	} else if function == "confirm" {
+		if len(args) != 2 && len(args) != 3 {
+			return shim.Error("Incorrect num of args, excepting 2 or 3")
+		}
+		fee := new(big.Int)
+		if len(args) == 3 {
+			var err error
+			fee, err = ParseAmount(args[2])
+			if err != nil {
+				return shim.Error(err.Error())
+			}
+		}
+		return t.confirm(stub, args[0], args[1], fee)
+	} else if function == "release" {
+		if len(args) != 1 {
+			return shim.Error("Incorrect num of args, excepting 1")
+		}
+		return t.release(stub, args[0])
+	} else if function == "reclaimExpired" {
+		if len(args) != 1 {
+			return shim.Error("Incorrect num of args, excepting 1")
+		}
+		return t.reclaimExpired(stub, args[0])
+	} else if function == "migrateHold" {
+		if len(args) != 3 {
+			return shim.Error("Incorrect num of args, excepting 3")
+		}
+		amount, err := ParseAmount(args[2])
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		return t.migrateHold(stub, args[0], args[1], amount)
+	} else if function == "setHoldTTL" {
+		if len(args) != 1 {
+			return shim.Error("Incorrect num of args, excepting 1")
+		}
+		ttl, err := strconv.Atoi(args[0])
+		if err != nil {
+			return shim.Error("参数转换为int类型异常")
+		}
+		return t.setHoldTTL(stub, ttl)
+	} else if function == "pruneHolds" {
+		if len(args) != 1 {
+			return shim.Error("Incorrect num of args, excepting 1")
+		}
+		return t.pruneHolds(stub, args[0])
+	} else if function == "getHold" {
+		if len(args) != 1 {
+			return shim.Error("Incorrect num of args, excepting 1")
+		}
+		return t.getHold(stub, args[0])
+	} else if function == "getHolds" {
+		if len(args) != 1 {
+			return shim.Error("Incorrect num of args, excepting 1")
+		}
+		return t.getHolds(stub, args[0])
+	} else if function == "getFreeze" {
+		if len(args) != 1 && len(args) != 2 {
+			return shim.Error("Incorrect num of args, excepting 1 or 2")
+		}
+		return t.getFreeze(stub, args[0], tokenArg(args, 1))
+	} else if function == "transfer" {
+		if len(args) != 5 && len(args) != 6 {
+			return shim.Error("Incorrect num of args, excepting 5 or 6")
+		}
+		return t.transfer(stub, args[0], args[1], args[2], args[3], args[4], tokenArg(args, 5))
+	} else if function == "batchTransfer" {
+		if len(args) != 4 && len(args) != 5 {
+			return shim.Error("Incorrect num of args, excepting 4 or 5")
+		}
+		return t.batchTransfer(stub, args[0], args[1], args[2], args[3], tokenArg(args, 4))
+	} else if function == "getBatch" {
+		if len(args) != 1 {
+			return shim.Error("Incorrect num of args, excepting 1")
+		}
+		return t.getBatch(stub, args[0])
+	} else if function == "burn" {
+		if len(args) != 5 && len(args) != 6 {
+			return shim.Error("Incorrect num of args, excepting 5 or 6")
+		}
+		return t.burn(stub, args[0], args[1], args[2], args[3], args[4], tokenArg(args, 5))
+	} else if function == "redeem" {
+		if len(args) != 5 && len(args) != 6 {
+			return shim.Error("Incorrect num of args, excepting 5 or 6")
+		}
+		return t.redeem(stub, args[0], args[1], args[2], args[3], args[4], tokenArg(args, 5))
+	} else if function == "getReceipt" {
+		if len(args) != 1 {
+			return shim.Error("Incorrect num of args, excepting 1")
+		}
+		return t.getReceipt(stub, args[0])
+	} else if function == "getReceipts" {
+		if len(args) != 1 {
+			return shim.Error("Incorrect num of args, excepting 1")
+		}
+		return t.getReceipts(stub, args[0])
+	} else if function == "lockBond" {
+		if len(args) != 4 {
+			return shim.Error("Incorrect num of args, excepting 4")
+		}
+		return t.lockBond(stub, args[0], args[1], args[2], args[3])
+	} else if function == "requestUnbond" {
+		if len(args) != 3 {
+			return shim.Error("Incorrect num of args, excepting 3")
+		}
+		return t.requestUnbond(stub, args[0], args[1], args[2])
+	} else if function == "withdrawBond" {
+		if len(args) != 3 {
+			return shim.Error("Incorrect num of args, excepting 3")
+		}
+		return t.withdrawBond(stub, args[0], args[1], args[2])
+	} else if function == "slashBond" {
+		if len(args) != 4 {
+			return shim.Error("Incorrect num of args, excepting 4")
+		}
+		amount, err := ParseAmount(args[2])
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		return t.slashBond(stub, args[0], args[1], amount, args[3])
+	} else if function == "getBond" {
+		if len(args) != 1 {
+			return shim.Error("Incorrect num of args, excepting 1")
+		}
+		return t.getBond(stub, args[0])
+	} else if function == "checkBond" {
+		if len(args) != 1 {
+			return shim.Error("Incorrect num of args, excepting 1")
+		}
+		return t.checkBond(stub, args[0])
+	} else if function == "setBondConfig" {
 		if len(args) != 2 {
 			return shim.Error("Incorrect num of args, excepting 2")
 		}
-		amount, err := strconv.Atoi(string(args[1]))
+		minBond, err := ParseAmount(args[0])
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		cooldown, err := strconv.Atoi(args[1])
 		if err != nil {
 			return shim.Error("参数转换为int类型异常")
 		}
-		return t.issue(stub, args[0], amount)
-	} else if function == "freeze" {
+		return t.setBondConfig(stub, minBond, cooldown)
+	} else if function == "setLevel" {
 		if len(args) != 2 {
 			return shim.Error("Incorrect num of args, excepting 2")
 		}
-		amount, err := strconv.Atoi(string(args[1]))
+		level, err := strconv.Atoi(args[1])
 		if err != nil {
 			return shim.Error("参数转换为int类型异常")
 		}
-		return t.freeze(stub, args[0], amount)
-	} else if function == "confirm" {
+		return t.setLevel(stub, args[0], level)
+	} else if function == "setLimit" {
 		if len(args) != 3 {
 			return shim.Error("Incorrect num of args, excepting 3")
 		}
-		amount, err := strconv.Atoi(string(args[2]))
+		level, err := strconv.Atoi(args[0])
 		if err != nil {
 			return shim.Error("参数转换为int类型异常")
 		}
-		return t.confirm(stub, args[0], args[1], amount)
-	} else if function == "get" {
+		perTx, err := ParseAmount(args[1])
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		daily, err := ParseAmount(args[2])
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		return t.setLimit(stub, level, perTx, daily)
+	} else if function == "getSpendLimit" {
+		if len(args) != 1 {
+			return shim.Error("Incorrect num of args, excepting 1")
+		}
+		return t.getSpendLimit(stub, args[0])
+	} else if function == "registerOracle" {
+		if len(args) != 2 {
+			return shim.Error("Incorrect num of args, excepting 2")
+		}
+		return t.registerOracle(stub, args[0], args[1])
+	} else if function == "deposit" {
+		if len(args) != 3 {
+			return shim.Error("Incorrect num of args, excepting 3")
+		}
+		return t.deposit(stub, args[0], args[1], args[2])
+	} else if function == "getDeposit" {
+		if len(args) != 1 {
+			return shim.Error("Incorrect num of args, excepting 1")
+		}
+		return t.getDeposit(stub, args[0])
+	} else if function == "requestWithdrawal" {
+		if len(args) != 4 && len(args) != 5 {
+			return shim.Error("Incorrect num of args, excepting 4 or 5")
+		}
+		return t.requestWithdrawal(stub, args[0], args[1], args[2], args[3], tokenArg(args, 4))
+	} else if function == "approveWithdrawal" {
+		if len(args) != 2 {
+			return shim.Error("Incorrect num of args, excepting 2")
+		}
+		return t.approveWithdrawal(stub, args[0], args[1])
+	} else if function == "rejectWithdrawal" {
+		if len(args) != 2 {
+			return shim.Error("Incorrect num of args, excepting 2")
+		}
+		return t.rejectWithdrawal(stub, args[0], args[1])
+	} else if function == "getWithdrawal" {
+		if len(args) != 1 {
+			return shim.Error("Incorrect num of args, excepting 1")
+		}
+		return t.getWithdrawal(stub, args[0])
+	} else if function == "getPendingWithdrawals" {
+		return t.getPendingWithdrawals(stub)
+	} else if function == "approve" {
+		if len(args) != 5 && len(args) != 6 {
+			return shim.Error("Incorrect num of args, excepting 5 or 6")
+		}
+		return t.approve(stub, args[0], args[1], args[2], args[3], args[4], tokenArg(args, 5))
+	} else if function == "allowance" {
+		if len(args) != 2 && len(args) != 3 {
+			return shim.Error("Incorrect num of args, excepting 2 or 3")
+		}
+		return t.allowance(stub, args[0], args[1], tokenArg(args, 2))
+	} else if function == "transferFrom" {
+		if len(args) != 3 && len(args) != 4 {
+			return shim.Error("Incorrect num of args, excepting 3 or 4")
+		}
+		amount, err := ParseAmount(args[2])
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		return t.transferFrom(stub, args[0], args[1], amount, tokenArg(args, 3))
+	} else if function == "freezeFrom" {
+		if len(args) != 3 && len(args) != 4 {
+			return shim.Error("Incorrect num of args, excepting 3 or 4")
+		}
+		amount, err := ParseAmount(args[1])
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		return t.freezeFrom(stub, args[0], amount, args[2], tokenArg(args, 3))
+	} else if function == "setTrustedCaller" {
+		if len(args) != 3 {
+			return shim.Error("Incorrect num of args, excepting 3")
+		}
+		return t.setTrustedCaller(stub, args[0], args[1], args[2])
+	} else if function == "setTreasury" {
 		if len(args) != 1 {
 			return shim.Error("Incorrect num of args, excepting 1")
 		}
-		return t.get(stub, args[0])
+		return t.setTreasury(stub, args[0])
+	} else if function == "treasuryIncome" {
+		return t.treasuryIncome(stub, tokenArg(args, 0))
+	} else if function == "createToken" {
+		if len(args) != 4 {
+			return shim.Error("Incorrect num of args, excepting 4")
+		}
+		decimals, err := strconv.Atoi(args[1])
+		if err != nil {
+			return shim.Error("参数转换为int类型异常")
+		}
+		maxSupply, err := ParseAmount(args[2])
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		return t.createToken(stub, args[0], decimals, maxSupply, args[3])
+	} else if function == "getToken" {
+		if len(args) != 1 {
+			return shim.Error("Incorrect num of args, excepting 1")
+		}
+		return t.getToken(stub, args[0])
+	} else if function == "setMaxSupply" {
+		if len(args) != 1 {
+			return shim.Error("Incorrect num of args, excepting 1")
+		}
+		maxSupply, err := ParseAmount(args[0])
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		return t.setMaxSupply(stub, maxSupply)
+	} else if function == "getHistory" {
+		if len(args) != 3 {
+			return shim.Error("Incorrect num of args, excepting 3")
+		}
+		pageSize, err := strconv.Atoi(string(args[1]))
+		if err != nil {
+			return shim.Error("参数转换为int类型异常")
+		}
+		return t.getHistory(stub, args[0], pageSize, args[2])
+	} else if function == "totalSupply" {
+		return t.totalSupply(stub, tokenArg(args, 0))
+	} else if function == "seedSupply" {
+		return t.seedSupply(stub)
+	} else if function == "get" {
+		if len(args) != 1 && len(args) != 2 {
+			return shim.Error("Incorrect num of args, excepting 1 or 2")
+		}
+		return t.get(stub, args[0], tokenArg(args, 1))
 	}
 	return shim.Error("function error")
 }
 
-func (t *CoinChaincode) issue(stub shim.ChaincodeStubInterface, pubKey string, amount int) pb.Response {
-	err := stub.PutState(pubKey+SUFFIX_COIN, []byte(strconv.Itoa(amount)))
+//发行, 在原有余额上增加
+//只允许管理员或币种发行方发行; 受信任合约(user注册赠送)只允许给从未持有过默认币种的账户发行
+func (t *CoinChaincode) issue(stub shim.ChaincodeStubInterface, pubKey string, amount *big.Int, symbol string) pb.Response {
+	if amount.Sign() <= 0 {
+		return shim.Error("amount must be positive")
+	}
+	token, err := mustGetToken(stub, symbol)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if err = token.checkPrecision(amount); err != nil {
+		return shim.Error(err.Error())
+	}
+	b, err := stub.GetState(balanceKey(pubKey, symbol))
+	if err != nil {
+		return shim.Error("get coin fail")
+	}
+	if !canIssue(stub, token) {
+		if checkCaller(stub, OP_ISSUE) != nil || symbol != DEFAULT_TOKEN || len(b) > 0 {
+			return shim.Error("没有发行权限")
+		}
+	}
+	if err = mintCoin(stub, OP_ISSUE, token, pubKey, amount, ""); err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success([]byte("ok"))
+}
+
+//增发到账户, 校验发行上限并记账
+func mintCoin(stub shim.ChaincodeStubInterface, op string, token *Token, pubKey string, amount *big.Int, ref string) error {
+	symbol := token.Symbol
+	balance, err := getAmountState(stub, balanceKey(pubKey, symbol))
+	if err != nil {
+		return err
+	}
+	//校验发行上限
+	total, err := getAmountState(stub, supplyKey(symbol))
+	if err != nil {
+		return err
+	}
+	newTotal, err := AddAmount(total, amount)
+	if err != nil {
+		return err
+	}
+	if token.MaxSupply.Sign() > 0 && newTotal.Cmp(&token.MaxSupply.Int) > 0 {
+		return fmt.Errorf("exceed max supply")
+	}
+	newBalance, err := AddAmount(balance, amount)
+	if err != nil {
+		return err
+	}
+
+	//更新数据
+	if err = putAmountState(stub, balanceKey(pubKey, symbol), newBalance); err != nil {
+		return fmt.Errorf("issue coin fail")
+	}
+	if err = putAmountState(stub, supplyKey(symbol), newTotal); err != nil {
+		return fmt.Errorf("issue coin fail")
+	}
+	return writeJournal(stub, op, symbol, ACCOUNT_MINT, pubKey, amount, ref)
+}
 
+//创建币种, 只允许管理员创建
+func (t *CoinChaincode) createToken(stub shim.ChaincodeStubInterface, symbol string, decimals int, maxSupply *big.Int, issuer string) pb.Response {
+	if !isAdmin(stub) {
+		return shim.Error("没有管理员权限")
+	}
+	if !symbolPattern.MatchString(symbol) {
+		return shim.Error("symbol格式错误")
+	}
+	if decimals < 0 || decimals > AMOUNT_SCALE {
+		return shim.Error(fmt.Sprintf("decimals必须在0到%d之间", AMOUNT_SCALE))
+	}
+	if len(issuer) == 0 {
+		return shim.Error("issuer必须填写")
+	}
+	old, err := getToken(stub, symbol)
 	if err != nil {
-		return shim.Error("issue coin fail")
+		return shim.Error(err.Error())
+	}
+	if old != nil {
+		return shim.Error("token already exists")
+	}
+	tm, err := stub.GetTxTimestamp()
+	if err != nil {
+		return shim.Error("get timestamp fail")
+	}
+	token := &Token{Symbol: symbol, Name: symbol, Decimals: decimals, Issuer: issuer, CreateTime: time.Unix(tm.Seconds, 0)}
+	if err = token.checkPrecision(maxSupply); err != nil {
+		return shim.Error(err.Error())
+	}
+	token.MaxSupply.Set(maxSupply)
+	if err = putToken(stub, token); err != nil {
+		return shim.Error("put fail")
 	}
 	return shim.Success([]byte("ok"))
 }
 
-func (t *CoinChaincode) get(stub shim.ChaincodeStubInterface, pubKey string) pb.Response {
-	b, err := stub.GetState(pubKey + SUFFIX_COIN)
+//查询币种定义
+func (t *CoinChaincode) getToken(stub shim.ChaincodeStubInterface, symbol string) pb.Response {
+	token, err := mustGetToken(stub, symbol)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(token.toString())
+}
+
+//设置默认币种发行上限, 0表示不限制
+func (t *CoinChaincode) setMaxSupply(stub shim.ChaincodeStubInterface, maxSupply *big.Int) pb.Response {
+	if !isAdmin(stub) {
+		return shim.Error("没有管理员权限")
+	}
+	total, err := getAmountState(stub, KEY_TOTAL_SUPPLY)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if maxSupply.Sign() > 0 && maxSupply.Cmp(total) < 0 {
+		return shim.Error("max supply less than total supply")
+	}
+	if err = putAmountState(stub, KEY_MAX_SUPPLY, maxSupply); err != nil {
+		return shim.Error("put fail")
+	}
+	return shim.Success([]byte("ok"))
+}
+
+//查询已发行总量
+func (t *CoinChaincode) totalSupply(stub shim.ChaincodeStubInterface, symbol string) pb.Response {
+	total, err := getAmountState(stub, supplyKey(symbol))
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success([]byte(FormatAmount(total)))
+}
+
+/**
+按链上现有的默认币种重新统计发行总量, 只允许管理员操作
+发行总量从增加发行上限后才开始累计, 升级前已发行的币需通过该迁移计入
+统计范围: 用户余额、旧版本累计冻结、未结算的冻结记录、商家保证金
+*/
+func (t *CoinChaincode) seedSupply(stub shim.ChaincodeStubInterface) pb.Response {
+	if !isAdmin(stub) {
+		return shim.Error("没有管理员权限")
+	}
+	total := new(big.Int)
+	rs, err := stub.GetStateByRange("", "")
+	if err != nil {
+		return shim.Error("系统异常")
+	}
+	defer rs.Close()
+	for rs.HasNext() {
+		kv, err := rs.Next()
+		if err != nil {
+			return shim.Error(fmt.Sprintf("find error: %s", err))
+		}
+		if !strings.HasSuffix(kv.Key, SUFFIX_COIN) && !strings.HasSuffix(kv.Key, SUFFIX_FREEZE) {
+			continue
+		}
+		v, err := ParseAmount(string(kv.Value))
+		if err != nil {
+			return shim.Error(fmt.Sprintf("amount data error: %s, %s", kv.Key, kv.Value))
+		}
+		total.Add(total, v)
+	}
+	holdRs, err := stub.GetStateByPartialCompositeKey(PRE_KEY_HOLD, []string{})
+	if err != nil {
+		return shim.Error("系统异常")
+	}
+	defer holdRs.Close()
+	for holdRs.HasNext() {
+		kv, err := holdRs.Next()
+		if err != nil {
+			return shim.Error(fmt.Sprintf("find error: %s", err))
+		}
+		var h Hold
+		if err = json.Unmarshal(kv.Value, &h); err != nil {
+			return shim.Error(fmt.Sprintf("hold json error: %s", err))
+		}
+		if h.State == HOLD_OPEN && (len(h.Token) == 0 || h.Token == DEFAULT_TOKEN) {
+			total.Add(total, &h.Amount.Int)
+		}
+	}
+	bondRs, err := stub.GetStateByPartialCompositeKey(PRE_KEY_BOND, []string{})
+	if err != nil {
+		return shim.Error("系统异常")
+	}
+	defer bondRs.Close()
+	for bondRs.HasNext() {
+		kv, err := bondRs.Next()
+		if err != nil {
+			return shim.Error(fmt.Sprintf("find error: %s", err))
+		}
+		var bond Bond
+		if err = json.Unmarshal(kv.Value, &bond); err != nil {
+			return shim.Error(fmt.Sprintf("bond json error: %s", err))
+		}
+		total.Add(total, &bond.Amount.Int)
+	}
+	if err = putAmountState(stub, KEY_TOTAL_SUPPLY, total); err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success([]byte(FormatAmount(total)))
+}
 
+func (t *CoinChaincode) get(stub shim.ChaincodeStubInterface, pubKey, symbol string) pb.Response {
+	balance, err := getAmountState(stub, balanceKey(pubKey, symbol))
 	if err != nil {
 		return shim.Error("get coin fail")
 	}
-	return shim.Success(b)
+	return shim.Success([]byte(FormatAmount(balance)))
 }
 
-func (t *CoinChaincode) freeze(stub shim.ChaincodeStubInterface, pubKey string, amount int) pb.Response {
+//冻结, 只能由受信任合约调用
+func (t *CoinChaincode) freeze(stub shim.ChaincodeStubInterface, pubKey string, amount *big.Int, holdID, symbol string) pb.Response {
+	if err := checkCaller(stub, OP_FREEZE); err != nil {
+		return shim.Error(err.Error())
+	}
+	return t.holdCoin(stub, pubKey, amount, holdID, symbol, true)
+}
+
+//按holdID生成一条冻结记录
+func (t *CoinChaincode) holdCoin(stub shim.ChaincodeStubInterface, pubKey string, amount *big.Int, holdID, symbol string, expire bool) pb.Response {
 	//校验用户是否存在
 	checkRs := checkUser(stub, pubKey)
 	if checkRs.GetStatus() != shim.OK {
-		return shim.Error("用户不存在")
+		return checkRs
+	}
+	if amount.Sign() <= 0 {
+		return shim.Error("amount must be positive")
+	}
+	token, err := mustGetToken(stub, symbol)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if err = token.checkPrecision(amount); err != nil {
+		return shim.Error(err.Error())
+	}
+	old, err := getHold(stub, holdID)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if old != nil {
+		return shim.Error("hold already exists")
+	}
+	//校验限额
+	if err = useSpendLimit(stub, pubKey, symbol, amount); err != nil {
+		return shim.Error(err.Error())
 	}
 	//获取用户余额
-	b, err := stub.GetState(pubKey + SUFFIX_COIN)
-	balance, err := strconv.Atoi(string(b))
+	balance, err := getAmountState(stub, balanceKey(pubKey, symbol))
 	if err != nil {
-		return shim.Error("参数转换为int类型异常")
+		return shim.Error(err.Error())
 	}
-	if balance < amount {
+	newBalance, err := SubAmount(balance, amount)
+	if err != nil {
 		return shim.Error("balance not enough")
 	}
-	fz, err := stub.GetState(pubKey + SUFFIX_FREEZE)
+	tm, err := stub.GetTxTimestamp()
 	if err != nil {
-		error_str := fmt.Sprintf("get data error: %s\n", err)
-		fmt.Println(error_str)
-		return shim.Error(error_str)
+		return shim.Error("get timestamp fail")
 	}
-	freeze := 0
-	if len(fz) > 0 {
-		freeze, err = strconv.Atoi(string(fz))
+	hold := &Hold{ID: holdID, Owner: pubKey, Token: symbol, State: HOLD_OPEN, CreateTime: time.Unix(tm.Seconds, 0)}
+	//提现冻结不设过期时间, 只能由管理员处理
+	if expire {
+		ttl, err := getHoldTTL(stub)
 		if err != nil {
-			error_str := fmt.Sprintf("string to int error: %s\n, %s", err, fz)
-			fmt.Println(error_str)
-			return shim.Error(error_str)
+			return shim.Error(err.Error())
 		}
+		hold.ExpireTime = hold.CreateTime.Add(time.Duration(ttl) * time.Second)
 	}
+	hold.Amount.Set(amount)
 
-	//计算冻结后余额
-	newBalance := balance - amount
-	newFreeze := freeze + amount
 	//更新数据
-	err = stub.PutState(pubKey+SUFFIX_COIN, []byte(strconv.Itoa(newBalance)))
-	err = stub.PutState(pubKey+SUFFIX_FREEZE, []byte(strconv.Itoa(newFreeze)))
+	if err = putAmountState(stub, balanceKey(pubKey, symbol), newBalance); err != nil {
+		return shim.Error("put fail")
+	}
+	if err = putHold(stub, hold); err != nil {
+		return shim.Error("put fail")
+	}
+	if err = writeJournal(stub, OP_FREEZE, symbol, pubKey, ACCOUNT_ESCROW, amount, holdID); err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success([]byte("ok"))
+}
+
+//结算, 冻结记录的币扣除手续费后转给to, 手续费记入平台收入账户, 只能由受信任合约调用
+func (t *CoinChaincode) confirm(stub shim.ChaincodeStubInterface, holdID, to string, fee *big.Int) pb.Response {
+	if err := checkCaller(stub, OP_CONFIRM); err != nil {
+		return shim.Error(err.Error())
+	}
+	//校验用户是否存在
+	checkTo := checkUser(stub, to)
+	if checkTo.GetStatus() != shim.OK {
+		return shim.Error(fmt.Sprintf("to: %s", checkTo.GetMessage()))
+	}
+	hold, err := getHold(stub, holdID)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if hold == nil {
+		return shim.Error("hold not find")
+	}
+	if hold.State != HOLD_OPEN {
+		return shim.Error("hold not open")
+	}
+	tm, err := stub.GetTxTimestamp()
 	if err != nil {
+		return shim.Error("get timestamp fail")
+	}
+	if hold.isExpired(time.Unix(tm.Seconds, 0)) {
+		return shim.Error("hold expired")
+	}
+	payout, err := SubAmount(&hold.Amount.Int, fee)
+	if err != nil {
+		return shim.Error("fee exceeds hold amount")
+	}
+	hold.State = HOLD_CONFIRM
+	hold.Payee = to
+	hold.Fee.Set(fee)
+	hold.CloseTime = time.Unix(tm.Seconds, 0)
+
+	//更新数据
+	if err = creditAccount(stub, hold.Token, to, payout); err != nil {
+		return shim.Error(err.Error())
+	}
+	if err = putHold(stub, hold); err != nil {
 		return shim.Error("put fail")
 	}
+	if err = writeJournal(stub, OP_CONFIRM, hold.Token, ACCOUNT_ESCROW, to, payout, holdID); err != nil {
+		return shim.Error(err.Error())
+	}
+	if fee.Sign() > 0 {
+		if err = collectFee(stub, hold.Token, fee, holdID); err != nil {
+			return shim.Error(err.Error())
+		}
+	}
 	return shim.Success([]byte("ok"))
 }
 
-func (t *CoinChaincode) confirm(stub shim.ChaincodeStubInterface, from, to string, amount int) pb.Response {
+//解冻, 将冻结记录的币退回余额, 只能由受信任合约调用
+func (t *CoinChaincode) release(stub shim.ChaincodeStubInterface, holdID string) pb.Response {
+	if err := checkCaller(stub, OP_RELEASE); err != nil {
+		return shim.Error(err.Error())
+	}
+	hold, err := getHold(stub, holdID)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if hold == nil {
+		return shim.Error("hold not find")
+	}
+	if hold.State != HOLD_OPEN {
+		return shim.Error("hold not open")
+	}
+	if err = refundHold(stub, hold, HOLD_RELEASE, OP_RELEASE); err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success([]byte("ok"))
+}
+
+//退回已过期未结算的冻结记录, 任何人都可以调用
+func (t *CoinChaincode) reclaimExpired(stub shim.ChaincodeStubInterface, holdID string) pb.Response {
+	hold, err := getHold(stub, holdID)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if hold == nil {
+		return shim.Error("hold not find")
+	}
+	if hold.State != HOLD_OPEN {
+		return shim.Error("hold not open")
+	}
+	tm, err := stub.GetTxTimestamp()
+	if err != nil {
+		return shim.Error("get timestamp fail")
+	}
+	if !hold.isExpired(time.Unix(tm.Seconds, 0)) {
+		return shim.Error("hold not expired")
+	}
+	if err = refundHold(stub, hold, HOLD_EXPIRE, OP_RECLAIM); err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success([]byte("ok"))
+}
+
+//设置冻结有效期(秒), 只允许管理员操作
+func (t *CoinChaincode) setHoldTTL(stub shim.ChaincodeStubInterface, ttl int) pb.Response {
+	if !isAdmin(stub) {
+		return shim.Error("没有管理员权限")
+	}
+	if ttl <= 0 {
+		return shim.Error("ttl must be positive")
+	}
+	if err := putIntState(stub, KEY_HOLD_TTL, ttl); err != nil {
+		return shim.Error("put fail")
+	}
+	return shim.Success([]byte("ok"))
+}
+
+//查询用户未结算的冻结记录
+func (t *CoinChaincode) getHolds(stub shim.ChaincodeStubInterface, pubKey string) pb.Response {
+	holds, err := getOpenHolds(stub, pubKey)
+	if err != nil {
+		return shim.Error(fmt.Sprintf("find error: %s", err))
+	}
+	json_holds, err := json.Marshal(holds)
+	if err != nil {
+		return shim.Error("json error")
+	}
+	return shim.Success(json_holds)
+}
+
+//查询用户某币种冻结总额, 由未结算的冻结记录汇总
+func (t *CoinChaincode) getFreeze(stub shim.ChaincodeStubInterface, pubKey, symbol string) pb.Response {
+	holds, err := getOpenHolds(stub, pubKey)
+	if err != nil {
+		return shim.Error(fmt.Sprintf("find error: %s", err))
+	}
+	total := new(big.Int)
+	for _, h := range holds {
+		if h.Token == symbol {
+			total.Add(total, &h.Amount.Int)
+		}
+	}
+	return shim.Success([]byte(FormatAmount(total)))
+}
+
+//分页查询账户流水, bookmark为空时从第一页开始
+func (t *CoinChaincode) getHistory(stub shim.ChaincodeStubInterface, pubKey string, pageSize int, bookmark string) pb.Response {
+	if pageSize <= 0 {
+		return shim.Error("pageSize must be positive")
+	}
+	page, err := getJournalPage(stub, pubKey, int32(pageSize), bookmark)
+	if err != nil {
+		return shim.Error(fmt.Sprintf("find error: %s", err))
+	}
+	json_page, err := json.Marshal(page)
+	if err != nil {
+		return shim.Error("json error")
+	}
+	return shim.Success(json_page)
+}
+
+//签名内容为 transfer|to|amount|nonce, 非默认币种为 transfer|to|amount|nonce|symbol
+//nonce必须大于from上一次使用的nonce
+func (t *CoinChaincode) transfer(stub shim.ChaincodeStubInterface, from, to, amount_str, nonce, sign, symbol string) pb.Response {
+	//签名信息校验
+	payload := []string{OP_TRANSFER, to, amount_str, nonce}
+	if symbol != DEFAULT_TOKEN {
+		payload = append(payload, symbol)
+	}
+	if !verifyAccount(stub, from, strings.Join(payload, "|"), sign) {
+		return shim.Error("签名验证失败")
+	}
+	amount, err := ParseAmount(amount_str)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if amount.Sign() <= 0 {
+		return shim.Error("amount must be positive")
+	}
+	token, err := mustGetToken(stub, symbol)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if err = token.checkPrecision(amount); err != nil {
+		return shim.Error(err.Error())
+	}
+	if from == to {
+		return shim.Error("from and to must be different")
+	}
 	//校验用户是否存在
 	checkFrom := checkUser(stub, from)
 	if checkFrom.GetStatus() != shim.OK {
-		return shim.Error("from不存在")
+		return shim.Error(fmt.Sprintf("from: %s", checkFrom.GetMessage()))
 	}
 	checkTo := checkUser(stub, to)
 	if checkTo.GetStatus() != shim.OK {
-		return shim.Error("to不存在")
+		return shim.Error(fmt.Sprintf("to: %s", checkTo.GetMessage()))
+	}
+	//防止重放
+	err = useNonce(stub, from, nonce)
+	if err != nil {
+		return shim.Error(err.Error())
 	}
+	//校验限额
+	if err = useSpendLimit(stub, from, symbol, amount); err != nil {
+		return shim.Error(err.Error())
+	}
+	err = moveCoin(stub, OP_TRANSFER, symbol, from, to, amount)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success([]byte("ok"))
+}
+
+//增加账户余额
+func creditAccount(stub shim.ChaincodeStubInterface, symbol, account string, amount *big.Int) error {
+	balance, err := getAmountState(stub, balanceKey(account, symbol))
+	if err != nil {
+		return err
+	}
+	newBalance, err := AddAmount(balance, amount)
+	if err != nil {
+		return err
+	}
+	if err = putAmountState(stub, balanceKey(account, symbol), newBalance); err != nil {
+		return fmt.Errorf("put fail")
+	}
+	return nil
+}
+
+//在两个账户余额之间转账并记账
+func moveCoin(stub shim.ChaincodeStubInterface, op, symbol, from, to string, amount *big.Int) error {
 	//获取用户余额
-	f_fz, err := stub.GetState(from + SUFFIX_FREEZE)
-	from_freeze, err := strconv.Atoi(string(f_fz))
+	from_balance, err := getAmountState(stub, balanceKey(from, symbol))
 	if err != nil {
-		return shim.Error("参数转换为int类型异常")
+		return err
 	}
-	if from_freeze < amount {
-		return shim.Error("from freeze not enough")
+	new_fb, err := SubAmount(from_balance, amount)
+	if err != nil {
+		return fmt.Errorf("balance not enough")
 	}
-	t_b, err := stub.GetState(to + SUFFIX_COIN)
-	to_balance, err := strconv.Atoi(string(t_b))
+	to_balance, err := getAmountState(stub, balanceKey(to, symbol))
 	if err != nil {
-		return shim.Error("get fail")
+		return err
+	}
+	new_tb, err := AddAmount(to_balance, amount)
+	if err != nil {
+		return err
 	}
-	//计算冻结后余额
-	new_fz := from_freeze - amount
-	new_tb := to_balance + amount
 	//更新数据
-	err = stub.PutState(from+SUFFIX_FREEZE, []byte(strconv.Itoa(new_fz)))
-	err = stub.PutState(to+SUFFIX_COIN, []byte(strconv.Itoa(new_tb)))
+	if err = putAmountState(stub, balanceKey(from, symbol), new_fb); err != nil {
+		return fmt.Errorf("put fail")
+	}
+	if err = putAmountState(stub, balanceKey(to, symbol), new_tb); err != nil {
+		return fmt.Errorf("put fail")
+	}
+	return writeJournal(stub, op, symbol, from, to, amount, "")
+}
+
+//校验签名及nonce, 签名内容为 op|参数...|nonce
+func verifySigned(stub shim.ChaincodeStubInterface, pubKey, sign, nonce string, fields ...string) error {
+	payload := append(fields, nonce)
+	if !verifyAccount(stub, pubKey, strings.Join(payload, "|"), sign) {
+		return fmt.Errorf("签名验证失败")
+	}
+	return useNonce(stub, pubKey, nonce)
+}
+
+//校验并记录nonce, 新nonce必须大于已使用的nonce
+func useNonce(stub shim.ChaincodeStubInterface, pubKey, nonce_str string) error {
+	nonce, err := strconv.Atoi(nonce_str)
 	if err != nil {
-		return shim.Error("put fail")
+		return fmt.Errorf("nonce必须为整数: %s", nonce_str)
 	}
-	return shim.Success([]byte("ok"))
+	last, err := getIntState(stub, pubKey+SUFFIX_NONCE)
+	if err != nil {
+		return err
+	}
+	if nonce <= last {
+		return fmt.Errorf("nonce已使用: %d", nonce)
+	}
+	return putIntState(stub, pubKey+SUFFIX_NONCE, nonce)
 }
 
-func checkUser(stub shim.ChaincodeStubInterface, pubKey string) pb.Response {
-	userResponse := stub.InvokeChaincode("user", [][]byte{[]byte("get"), []byte(pubKey)}, "")
-	if userResponse.GetStatus() != shim.OK {
-		return userResponse
+//读取int类型数据, 不存在时返回0
+func getIntState(stub shim.ChaincodeStubInterface, key string) (int, error) {
+	b, err := stub.GetState(key)
+	if err != nil {
+		return 0, fmt.Errorf("get data error: %s", err)
+	}
+	if len(b) == 0 {
+		return 0, nil
 	}
-	user_str := userResponse.GetPayload()
+	v, err := strconv.Atoi(string(b))
+	if err != nil {
+		return 0, fmt.Errorf("string to int error: %s, %s", err, b)
+	}
+	return v, nil
+}
 
-	if len(user_str) > 0 {
-		return shim.Success([]byte("ok"))
-	} else {
-		return shim.Error("用户不存在")
+func putIntState(stub shim.ChaincodeStubInterface, key string, v int) error {
+	return stub.PutState(key, []byte(strconv.Itoa(v)))
+}
+
+//读取金额数据, 不存在时返回0, 兼容旧的整数格式
+func getAmountState(stub shim.ChaincodeStubInterface, key string) (*big.Int, error) {
+	b, err := stub.GetState(key)
+	if err != nil {
+		return nil, fmt.Errorf("get data error: %s", err)
+	}
+	if len(b) == 0 {
+		return new(big.Int), nil
 	}
+	v, err := ParseAmount(string(b))
+	if err != nil {
+		return nil, fmt.Errorf("amount data error: %s, %s", err, b)
+	}
+	return v, nil
+}
+
+func putAmountState(stub shim.ChaincodeStubInterface, key string, v *big.Int) error {
+	if err := CheckAmount(v); err != nil {
+		return err
+	}
+	return stub.PutState(key, []byte(FormatAmount(v)))
+}
+
+//校验用户存在且未被停用
+func checkUser(stub shim.ChaincodeStubInterface, pubKey string) pb.Response {
+	return stub.InvokeChaincode("user", [][]byte{[]byte("checkActive"), []byte(pubKey)}, "")
 }
 
 func main() {
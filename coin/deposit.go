@@ -0,0 +1,164 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+	"time"
+)
+
+const PRE_KEY_ORACLE = "coin_oracle"
+const PRE_KEY_DEPOSIT = "coin_deposit"
+
+const OP_DEPOSIT = "deposit"
+
+/**
+支付网关出具的充值凭证, 由已登记的网关公钥对凭证json原文签名
+ID为支付渠道的流水号, 同一ID只能入账一次
+*/
+type DepositReceipt struct {
+	ID      string
+	Account string
+	Amount  string
+	Token   string
+}
+
+//入账记录
+type Deposit struct {
+	ID      string
+	TxID    string
+	Oracle  string
+	Account string
+	Token   string
+	Amount  Amount
+	Time    time.Time
+}
+
+func (d *Deposit) toString() []byte {
+	if data, err := json.Marshal(d); err == nil {
+		return data
+	}
+	return []byte("err")
+}
+
+//登记或停用支付网关公钥, 只允许管理员操作
+func (t *CoinChaincode) registerOracle(stub shim.ChaincodeStubInterface, oracle, enabled string) pb.Response {
+	if !isAdmin(stub) {
+		return shim.Error("没有管理员权限")
+	}
+	if len(oracle) == 0 {
+		return shim.Error("oracle参数异常")
+	}
+	if enabled != "true" && enabled != "false" {
+		return shim.Error("enabled必须为true或false")
+	}
+	key, err := stub.CreateCompositeKey(PRE_KEY_ORACLE, []string{oracle})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if err = stub.PutState(key, []byte(enabled)); err != nil {
+		return shim.Error("put fail")
+	}
+	return shim.Success([]byte("ok"))
+}
+
+func isOracle(stub shim.ChaincodeStubInterface, oracle string) (bool, error) {
+	key, err := stub.CreateCompositeKey(PRE_KEY_ORACLE, []string{oracle})
+	if err != nil {
+		return false, err
+	}
+	b, err := stub.GetState(key)
+	if err != nil {
+		return false, fmt.Errorf("get data error: %s", err)
+	}
+	return string(b) == "true", nil
+}
+
+/**
+充值入账, 校验网关签名后给用户增发
+签名内容为receipt_str原文
+*/
+func (t *CoinChaincode) deposit(stub shim.ChaincodeStubInterface, oracle, receipt_str, sign string) pb.Response {
+	ok, err := isOracle(stub, oracle)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if !ok {
+		return shim.Error("oracle未登记")
+	}
+	if !Verify(oracle, receipt_str, sign) {
+		return shim.Error("签名验证失败")
+	}
+	var receipt DepositReceipt
+	err = json.Unmarshal([]byte(receipt_str), &receipt)
+	if err != nil {
+		return shim.Error("receipt json error")
+	}
+	if len(receipt.ID) == 0 || len(receipt.Account) == 0 {
+		return shim.Error("receipt参数异常")
+	}
+	if len(receipt.Token) == 0 {
+		receipt.Token = DEFAULT_TOKEN
+	}
+	amount, err := ParseAmount(receipt.Amount)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if amount.Sign() <= 0 {
+		return shim.Error("amount must be positive")
+	}
+	token, err := mustGetToken(stub, receipt.Token)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if err = token.checkPrecision(amount); err != nil {
+		return shim.Error(err.Error())
+	}
+	//防止同一凭证重复入账
+	key, err := stub.CreateCompositeKey(PRE_KEY_DEPOSIT, []string{receipt.ID})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	b, err := stub.GetState(key)
+	if err != nil {
+		return shim.Error("get data error")
+	}
+	if len(b) > 0 {
+		return shim.Error("receipt already used")
+	}
+	checkRs := checkUser(stub, receipt.Account)
+	if checkRs.GetStatus() != shim.OK {
+		return checkRs
+	}
+	if err = mintCoin(stub, OP_DEPOSIT, token, receipt.Account, amount, receipt.ID); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	tm, err := stub.GetTxTimestamp()
+	if err != nil {
+		return shim.Error("get timestamp fail")
+	}
+	d := &Deposit{ID: receipt.ID, TxID: stub.GetTxID(), Oracle: oracle, Account: receipt.Account, Token: token.Symbol, Time: time.Unix(tm.Seconds, 0)}
+	d.Amount.Set(amount)
+	if err = stub.PutState(key, d.toString()); err != nil {
+		return shim.Error("put fail")
+	}
+	return shim.Success(d.toString())
+}
+
+//按支付流水号查询入账记录
+func (t *CoinChaincode) getDeposit(stub shim.ChaincodeStubInterface, receiptID string) pb.Response {
+	key, err := stub.CreateCompositeKey(PRE_KEY_DEPOSIT, []string{receiptID})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	b, err := stub.GetState(key)
+	if err != nil {
+		return shim.Error("get data error")
+	}
+	if len(b) == 0 {
+		return shim.Error("deposit not find")
+	}
+	return shim.Success(b)
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"testing"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+const TEST_ACCOUNT = "pubkey1"
+
+//模拟user链码, checkActive和getKey都返回成功
+type mockUserChaincode struct {
+}
+
+func (t *mockUserChaincode) Init(stub shim.ChaincodeStubInterface) pb.Response {
+	return shim.Success(nil)
+}
+
+func (t *mockUserChaincode) Invoke(stub shim.ChaincodeStubInterface) pb.Response {
+	function, args := stub.GetFunctionAndParameters()
+	if function == "checkActive" {
+		return shim.Success([]byte("ok"))
+	} else if function == "getKey" {
+		return shim.Success([]byte(args[0]))
+	}
+	return shim.Error("unknown function")
+}
+
+//本地生成的网关密钥, 公钥和签名编码与Verify一致
+type testOracle struct {
+	key    *ecdsa.PrivateKey
+	pubKey string
+}
+
+func newTestOracle(t *testing.T) *testOracle {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("generate key: %s", err)
+	}
+	return &testOracle{key: key, pubKey: base64.StdEncoding.EncodeToString(append(fixedBytes(key.X), fixedBytes(key.Y)...))}
+}
+
+func (o *testOracle) sign(t *testing.T, data string) string {
+	digest := sha256.Sum256([]byte(data))
+	r, s, err := ecdsa.Sign(rand.Reader, o.key, digest[:])
+	if err != nil {
+		t.Fatalf("sign: %s", err)
+	}
+	return base64.StdEncoding.EncodeToString(append(fixedBytes(r), fixedBytes(s)...))
+}
+
+//补齐到32字节, 保证Verify按长度对半拆分时正确
+func fixedBytes(v *big.Int) []byte {
+	b := make([]byte, 32)
+	vb := v.Bytes()
+	copy(b[32-len(vb):], vb)
+	return b
+}
+
+func newDepositStub(t *testing.T) *shim.MockStub {
+	stub := shim.NewMockStub("coin", new(CoinChaincode))
+	stub.MockPeerChaincode("user", shim.NewMockStub("user", new(mockUserChaincode)))
+	rs := stub.MockInit("init", [][]byte{[]byte("init"), []byte("Org1MSP"), []byte("0")})
+	if rs.GetStatus() != shim.OK {
+		t.Fatalf("init fail: %s", rs.GetMessage())
+	}
+	return stub
+}
+
+//直接写入网关登记, 测试不依赖管理员身份
+func registerTestOracle(t *testing.T, stub *shim.MockStub, oracle string) {
+	stub.MockTransactionStart("register")
+	defer stub.MockTransactionEnd("register")
+	key, err := stub.CreateCompositeKey(PRE_KEY_ORACLE, []string{oracle})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = stub.PutState(key, []byte("true")); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func receiptJson(t *testing.T, id, amount string) string {
+	data, err := json.Marshal(&DepositReceipt{ID: id, Account: TEST_ACCOUNT, Amount: amount})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func invokeDeposit(stub *shim.MockStub, txID, oracle, receipt, sign string) pb.Response {
+	return stub.MockInvoke(txID, [][]byte{[]byte("deposit"), []byte(oracle), []byte(receipt), []byte(sign)})
+}
+
+func balanceOf(t *testing.T, stub *shim.MockStub) *big.Int {
+	v, err := getAmountState(stub, balanceKey(TEST_ACCOUNT, DEFAULT_TOKEN))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return v
+}
+
+func TestDepositCreditsBalance(t *testing.T) {
+	stub := newDepositStub(t)
+	oracle := newTestOracle(t)
+	registerTestOracle(t, stub, oracle.pubKey)
+
+	receipt := receiptJson(t, "pay001", "100")
+	rs := invokeDeposit(stub, "tx1", oracle.pubKey, receipt, oracle.sign(t, receipt))
+	if rs.GetStatus() != shim.OK {
+		t.Fatalf("deposit fail: %s", rs.GetMessage())
+	}
+	want, _ := ParseAmount("100")
+	if got := balanceOf(t, stub); got.Cmp(want) != 0 {
+		t.Fatalf("balance = %s, want %s", FormatAmount(got), FormatAmount(want))
+	}
+	rs = stub.MockInvoke("tx2", [][]byte{[]byte("getDeposit"), []byte("pay001")})
+	if rs.GetStatus() != shim.OK {
+		t.Fatalf("getDeposit fail: %s", rs.GetMessage())
+	}
+}
+
+func TestDepositRejectsBadSignature(t *testing.T) {
+	stub := newDepositStub(t)
+	oracle := newTestOracle(t)
+	registerTestOracle(t, stub, oracle.pubKey)
+
+	receipt := receiptJson(t, "pay001", "100")
+	//签名内容与提交的凭证不一致
+	sign := oracle.sign(t, receiptJson(t, "pay001", "1000"))
+	rs := invokeDeposit(stub, "tx1", oracle.pubKey, receipt, sign)
+	if rs.GetStatus() == shim.OK {
+		t.Fatal("deposit with bad signature should fail")
+	}
+	if got := balanceOf(t, stub); got.Sign() != 0 {
+		t.Fatalf("balance = %s, want 0", FormatAmount(got))
+	}
+}
+
+func TestDepositRejectsUnregisteredOracle(t *testing.T) {
+	stub := newDepositStub(t)
+	oracle := newTestOracle(t)
+
+	receipt := receiptJson(t, "pay001", "100")
+	rs := invokeDeposit(stub, "tx1", oracle.pubKey, receipt, oracle.sign(t, receipt))
+	if rs.GetStatus() == shim.OK {
+		t.Fatal("deposit from unregistered oracle should fail")
+	}
+	if got := balanceOf(t, stub); got.Sign() != 0 {
+		t.Fatalf("balance = %s, want 0", FormatAmount(got))
+	}
+}
+
+func TestDepositRejectsReusedReceipt(t *testing.T) {
+	stub := newDepositStub(t)
+	oracle := newTestOracle(t)
+	registerTestOracle(t, stub, oracle.pubKey)
+
+	receipt := receiptJson(t, "pay001", "100")
+	rs := invokeDeposit(stub, "tx1", oracle.pubKey, receipt, oracle.sign(t, receipt))
+	if rs.GetStatus() != shim.OK {
+		t.Fatalf("deposit fail: %s", rs.GetMessage())
+	}
+	//同一流水号换金额重新签名也不能再次入账
+	receipt = receiptJson(t, "pay001", "50")
+	rs = invokeDeposit(stub, "tx2", oracle.pubKey, receipt, oracle.sign(t, receipt))
+	if rs.GetStatus() == shim.OK {
+		t.Fatal("reused receipt should fail")
+	}
+	want, _ := ParseAmount("100")
+	if got := balanceOf(t, stub); got.Cmp(want) != 0 {
+		t.Fatalf("balance = %s, want %s", FormatAmount(got), FormatAmount(want))
+	}
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"encoding/json"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+const DIRECTION_IN = "in"
+const DIRECTION_OUT = "out"
+
+//资金变动事件, 同一交易内的变动合并为一个事件
+type CoinEvent struct {
+	TxID      string
+	Function  string
+	Movements []Movement
+}
+
+//单个账户的资金变动, TradeID为冻结相关操作对应的交易ID
+type Movement struct {
+	Account   string
+	Token     string
+	Amount    Amount
+	Direction string
+	Op        string
+	TradeID   string
+	Ref       string
+}
+
+/**
+记录本次调用资金变动的stub
+fabric每笔交易只保留最后一次SetEvent, 因此先收集变动, 调用成功后统一发送
+*/
+type eventStub struct {
+	shim.ChaincodeStubInterface
+	movements []Movement
+}
+
+//冻结相关操作的Ref为交易ID
+var holdOps = map[string]bool{
+	OP_FREEZE:  true,
+	OP_CONFIRM: true,
+	OP_RELEASE: true,
+	OP_RECLAIM: true,
+	OP_FEE:     true,
+}
+
+func (es *eventStub) record(e *JournalEntry) {
+	m := Movement{Account: e.Account, Token: e.Token, Op: e.Op, Ref: e.Ref, Direction: DIRECTION_IN}
+	m.Amount.Abs(&e.Amount.Int)
+	if e.Amount.Sign() < 0 {
+		m.Direction = DIRECTION_OUT
+	}
+	if holdOps[e.Op] {
+		m.TradeID = e.Ref
+	}
+	es.movements = append(es.movements, m)
+}
+
+//发送事件, 事件名为 coin.<function>
+func (es *eventStub) flush(function string) error {
+	if len(es.movements) == 0 {
+		return nil
+	}
+	payload, err := json.Marshal(&CoinEvent{TxID: es.GetTxID(), Function: function, Movements: es.movements})
+	if err != nil {
+		return err
+	}
+	return es.SetEvent("coin."+function, payload)
+}
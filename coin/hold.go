@@ -0,0 +1,243 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+	"math/big"
+	"strconv"
+	"time"
+)
+
+const PRE_KEY_HOLD = "coin_hold"
+//用户索引只保留未结算的冻结记录
+const PRE_KEY_HOLD_OWNER = "coin_hold_owner"
+const KEY_HOLD_TTL = "coin_hold_ttl"
+
+//旧版本按用户累计的冻结金额
+const SUFFIX_FREEZE = "_coin_freeze"
+
+//默认冻结有效期7天
+const DEFAULT_HOLD_TTL = 7 * 24 * 3600
+
+const HOLD_OPEN = 1
+const HOLD_CONFIRM = 2
+const HOLD_RELEASE = 3
+const HOLD_EXPIRE = 4
+
+//冻结记录, ID为交易ID, 超过ExpireTime未结算可退回
+type Hold struct {
+	ID         string
+	Owner      string
+	Payee      string
+	Token      string
+	Amount     Amount
+	Fee        Amount
+	State      int
+	CreateTime time.Time
+	ExpireTime time.Time
+	CloseTime  time.Time
+}
+
+//旧数据没有过期时间, 视为不过期
+func (h *Hold) isExpired(now time.Time) bool {
+	return !h.ExpireTime.IsZero() && now.After(h.ExpireTime)
+}
+
+func (h *Hold) toString() []byte {
+	if data, err := json.Marshal(h); err == nil {
+		return data
+	}
+	return []byte("err")
+}
+
+//读取冻结记录, 不存在时返回nil
+func getHold(stub shim.ChaincodeStubInterface, holdID string) (*Hold, error) {
+	key, err := stub.CreateCompositeKey(PRE_KEY_HOLD, []string{holdID})
+	if err != nil {
+		return nil, err
+	}
+	b, err := stub.GetState(key)
+	if err != nil {
+		return nil, fmt.Errorf("get data error: %s", err)
+	}
+	if len(b) == 0 {
+		return nil, nil
+	}
+	var h Hold
+	err = json.Unmarshal(b, &h)
+	if err != nil {
+		return nil, fmt.Errorf("hold json error: %s", err)
+	}
+	if len(h.Token) == 0 {
+		h.Token = DEFAULT_TOKEN
+	}
+	return &h, nil
+}
+
+//写入冻结记录, 冻结结算或退回后删除用户索引
+func putHold(stub shim.ChaincodeStubInterface, h *Hold) error {
+	key, err := stub.CreateCompositeKey(PRE_KEY_HOLD, []string{h.ID})
+	if err != nil {
+		return err
+	}
+	ownerKey, err := stub.CreateCompositeKey(PRE_KEY_HOLD_OWNER, []string{h.Owner, h.ID})
+	if err != nil {
+		return err
+	}
+	err = stub.PutState(key, h.toString())
+	if err != nil {
+		return err
+	}
+	if h.State != HOLD_OPEN {
+		return stub.DelState(ownerKey)
+	}
+	return stub.PutState(ownerKey, []byte(h.ID))
+}
+
+//冻结有效期(秒), 未配置时为默认值
+func getHoldTTL(stub shim.ChaincodeStubInterface) (int, error) {
+	ttl, err := getIntState(stub, KEY_HOLD_TTL)
+	if err != nil {
+		return 0, err
+	}
+	if ttl <= 0 {
+		return DEFAULT_HOLD_TTL, nil
+	}
+	return ttl, nil
+}
+
+//将冻结记录的币退回owner余额
+func refundHold(stub shim.ChaincodeStubInterface, h *Hold, state int, op string) error {
+	tm, err := stub.GetTxTimestamp()
+	if err != nil {
+		return fmt.Errorf("get timestamp fail")
+	}
+	h.State = state
+	h.CloseTime = time.Unix(tm.Seconds, 0)
+
+	//更新数据
+	if err = creditAccount(stub, h.Token, h.Owner, &h.Amount.Int); err != nil {
+		return err
+	}
+	if err = putHold(stub, h); err != nil {
+		return fmt.Errorf("put fail")
+	}
+	return writeJournal(stub, op, h.Token, ACCOUNT_ESCROW, h.Owner, &h.Amount.Int, h.ID)
+}
+
+/**
+迁移旧版本的冻结金额, 只允许管理员操作
+从用户累计冻结金额中扣除, 生成以旧交易提交ID为key的不过期冻结记录, 之后可正常结算或退回
+*/
+func (t *CoinChaincode) migrateHold(stub shim.ChaincodeStubInterface, owner, holdID string, amount *big.Int) pb.Response {
+	if !isAdmin(stub) {
+		return shim.Error("没有管理员权限")
+	}
+	if amount.Sign() <= 0 {
+		return shim.Error("amount must be positive")
+	}
+	old, err := getHold(stub, holdID)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if old != nil {
+		return shim.Error("hold already exists")
+	}
+	freeze, err := getAmountState(stub, owner+SUFFIX_FREEZE)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	newFreeze, err := SubAmount(freeze, amount)
+	if err != nil {
+		return shim.Error("freeze not enough")
+	}
+	tm, err := stub.GetTxTimestamp()
+	if err != nil {
+		return shim.Error("get timestamp fail")
+	}
+	hold := &Hold{ID: holdID, Owner: owner, Token: DEFAULT_TOKEN, State: HOLD_OPEN, CreateTime: time.Unix(tm.Seconds, 0)}
+	hold.Amount.Set(amount)
+
+	//更新数据
+	if err = putAmountState(stub, owner+SUFFIX_FREEZE, newFreeze); err != nil {
+		return shim.Error("put fail")
+	}
+	if err = putHold(stub, hold); err != nil {
+		return shim.Error("put fail")
+	}
+	return shim.Success(hold.toString())
+}
+
+//查询冻结记录
+func (t *CoinChaincode) getHold(stub shim.ChaincodeStubInterface, holdID string) pb.Response {
+	hold, err := getHold(stub, holdID)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if hold == nil {
+		return shim.Error("hold not find")
+	}
+	return shim.Success(hold.toString())
+}
+
+/**
+清理用户索引中已结算的冻结记录, 只允许管理员操作
+旧版本结算后没有删除索引, 返回清理的条数
+*/
+func (t *CoinChaincode) pruneHolds(stub shim.ChaincodeStubInterface, pubKey string) pb.Response {
+	if !isAdmin(stub) {
+		return shim.Error("没有管理员权限")
+	}
+	rs, err := stub.GetStateByPartialCompositeKey(PRE_KEY_HOLD_OWNER, []string{pubKey})
+	if err != nil {
+		return shim.Error(fmt.Sprintf("find error: %s", err))
+	}
+	defer rs.Close()
+
+	count := 0
+	for rs.HasNext() {
+		responseRange, err := rs.Next()
+		if err != nil {
+			return shim.Error(fmt.Sprintf("find error: %s", err))
+		}
+		h, err := getHold(stub, string(responseRange.Value))
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		if h != nil && h.State == HOLD_OPEN {
+			continue
+		}
+		if err = stub.DelState(responseRange.Key); err != nil {
+			return shim.Error("put fail")
+		}
+		count++
+	}
+	return shim.Success([]byte(strconv.Itoa(count)))
+}
+
+//查询用户未结算的冻结记录
+func getOpenHolds(stub shim.ChaincodeStubInterface, pubKey string) ([]*Hold, error) {
+	rs, err := stub.GetStateByPartialCompositeKey(PRE_KEY_HOLD_OWNER, []string{pubKey})
+	if err != nil {
+		return nil, err
+	}
+	defer rs.Close()
+
+	holds := []*Hold{}
+	for rs.HasNext() {
+		responseRange, err := rs.Next()
+		if err != nil {
+			return nil, err
+		}
+		h, err := getHold(stub, string(responseRange.Value))
+		if err != nil {
+			return nil, err
+		}
+		if h != nil && h.State == HOLD_OPEN {
+			holds = append(holds, h)
+		}
+	}
+	return holds, nil
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"math/big"
+	"time"
+)
+
+const PRE_KEY_JOURNAL = "coin_journal"
+
+//系统账户, 作为发行和冻结的对手方
+const ACCOUNT_MINT = "@mint"
+const ACCOUNT_ESCROW = "@escrow"
+
+const OP_ISSUE = "issue"
+const OP_FREEZE = "freeze"
+const OP_CONFIRM = "confirm"
+const OP_RELEASE = "release"
+const OP_RECLAIM = "reclaim"
+const OP_TRANSFER = "transfer"
+
+//账户流水, Amount为正表示转入, 为负表示转出
+type JournalEntry struct {
+	TxID         string
+	Account      string
+	Counterparty string
+	Op           string
+	Token        string
+	Amount       Amount
+	Ref          string
+	Time         time.Time
+}
+
+type JournalPage struct {
+	Records  []JournalEntry
+	Count    int32
+	Bookmark string
+}
+
+/**
+记账, 每笔变动在转出方和转入方各写一条流水
+key为 coin_journal + 账户 + 时间 + 交易ID + 操作 + 对手方, 保证同一账户按时间排序
+*/
+func writeJournal(stub shim.ChaincodeStubInterface, op, symbol, from, to string, amount *big.Int, ref string) error {
+	tm, err := stub.GetTxTimestamp()
+	if err != nil {
+		return fmt.Errorf("get timestamp fail: %s", err)
+	}
+	now := time.Unix(tm.Seconds, 0)
+	debit := JournalEntry{TxID: stub.GetTxID(), Account: from, Counterparty: to, Op: op, Token: symbol, Ref: ref, Time: now}
+	debit.Amount.Neg(amount)
+	credit := JournalEntry{TxID: stub.GetTxID(), Account: to, Counterparty: from, Op: op, Token: symbol, Ref: ref, Time: now}
+	credit.Amount.Set(amount)
+	for _, e := range []*JournalEntry{&debit, &credit} {
+		key, err := stub.CreateCompositeKey(PRE_KEY_JOURNAL, []string{e.Account, fmt.Sprintf("%012d", tm.Seconds), e.TxID, e.Op, e.Counterparty})
+		if err != nil {
+			return err
+		}
+		data, err := json.Marshal(e)
+		if err != nil {
+			return err
+		}
+		err = stub.PutState(key, data)
+		if err != nil {
+			return fmt.Errorf("put journal fail: %s", err)
+		}
+		if es, ok := stub.(*eventStub); ok {
+			es.record(e)
+		}
+	}
+	return nil
+}
+
+//分页查询账户流水
+func getJournalPage(stub shim.ChaincodeStubInterface, pubKey string, pageSize int32, bookmark string) (*JournalPage, error) {
+	rs, metadata, err := stub.GetStateByPartialCompositeKeyWithPagination(PRE_KEY_JOURNAL, []string{pubKey}, pageSize, bookmark)
+	if err != nil {
+		return nil, err
+	}
+	defer rs.Close()
+
+	page := &JournalPage{Records: []JournalEntry{}}
+	for rs.HasNext() {
+		responseRange, err := rs.Next()
+		if err != nil {
+			return nil, err
+		}
+		var e JournalEntry
+		err = json.Unmarshal(responseRange.Value, &e)
+		if err != nil {
+			return nil, fmt.Errorf("journal json error: %s", err)
+		}
+		page.Records = append(page.Records, e)
+	}
+	page.Count = metadata.GetFetchedRecordsCount()
+	page.Bookmark = metadata.GetBookmark()
+	return page, nil
+}
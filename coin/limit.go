@@ -0,0 +1,229 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+	"math/big"
+	"strconv"
+	"time"
+)
+
+const PRE_KEY_LIMIT = "coin_limit"
+const SUFFIX_LEVEL = "_coin_level"
+const SUFFIX_SPEND = "_coin_spend"
+
+//日限额按最近24小时滚动计算
+const LIMIT_WINDOW = 24 * time.Hour
+
+/**
+某认证等级的限额, 只对默认币种生效
+PerTx为单笔限额, Daily为24小时累计限额, 0表示不限制
+*/
+type Limit struct {
+	Level int
+	PerTx Amount
+	Daily Amount
+}
+
+//一笔支出记录
+type Spend struct {
+	Time   int64
+	Amount Amount
+}
+
+//剩余额度查询结果, Daily为0表示不限额, 此时不返回Remaining
+type LimitStatus struct {
+	Level     int
+	PerTx     Amount
+	Daily     Amount
+	Spent     Amount
+	Remaining *Amount `json:",omitempty"`
+}
+
+//用户认证等级, 未设置时为0
+func getLevel(stub shim.ChaincodeStubInterface, pubKey string) (int, error) {
+	return getIntState(stub, pubKey+SUFFIX_LEVEL)
+}
+
+//读取认证等级的限额, 未配置时使用等级0的限额, 都未配置时返回nil
+func getLimit(stub shim.ChaincodeStubInterface, level int) (*Limit, error) {
+	for _, l := range []int{level, 0} {
+		key, err := stub.CreateCompositeKey(PRE_KEY_LIMIT, []string{strconv.Itoa(l)})
+		if err != nil {
+			return nil, err
+		}
+		b, err := stub.GetState(key)
+		if err != nil {
+			return nil, fmt.Errorf("get data error: %s", err)
+		}
+		if len(b) == 0 {
+			continue
+		}
+		var limit Limit
+		err = json.Unmarshal(b, &limit)
+		if err != nil {
+			return nil, fmt.Errorf("limit json error: %s", err)
+		}
+		return &limit, nil
+	}
+	return nil, nil
+}
+
+//读取最近24小时内的支出记录
+func getSpends(stub shim.ChaincodeStubInterface, pubKey string, now time.Time) ([]Spend, *big.Int, error) {
+	b, err := stub.GetState(pubKey + SUFFIX_SPEND)
+	if err != nil {
+		return nil, nil, fmt.Errorf("get data error: %s", err)
+	}
+	var all []Spend
+	if len(b) > 0 {
+		err = json.Unmarshal(b, &all)
+		if err != nil {
+			return nil, nil, fmt.Errorf("spend json error: %s", err)
+		}
+	}
+	spends := []Spend{}
+	total := new(big.Int)
+	since := now.Add(-LIMIT_WINDOW).Unix()
+	for _, s := range all {
+		if s.Time > since {
+			spends = append(spends, s)
+			total.Add(total, &s.Amount.Int)
+		}
+	}
+	return spends, total, nil
+}
+
+/**
+校验并记录支出, 超过单笔或24小时限额时报错
+同一交易内只能调用一次
+*/
+func useSpendLimit(stub shim.ChaincodeStubInterface, pubKey, symbol string, amount *big.Int) error {
+	if symbol != DEFAULT_TOKEN {
+		return nil
+	}
+	level, err := getLevel(stub, pubKey)
+	if err != nil {
+		return err
+	}
+	limit, err := getLimit(stub, level)
+	if err != nil {
+		return err
+	}
+	if limit == nil {
+		return nil
+	}
+	if limit.PerTx.Sign() > 0 && amount.Cmp(&limit.PerTx.Int) > 0 {
+		return fmt.Errorf("超过单笔限额%s", FormatAmount(&limit.PerTx.Int))
+	}
+	if limit.Daily.Sign() == 0 {
+		return nil
+	}
+	tm, err := stub.GetTxTimestamp()
+	if err != nil {
+		return fmt.Errorf("get timestamp fail")
+	}
+	now := time.Unix(tm.Seconds, 0)
+	spends, spent, err := getSpends(stub, pubKey, now)
+	if err != nil {
+		return err
+	}
+	newSpent, err := AddAmount(spent, amount)
+	if err != nil {
+		return err
+	}
+	if newSpent.Cmp(&limit.Daily.Int) > 0 {
+		return fmt.Errorf("超过24小时限额%s, 已使用%s", FormatAmount(&limit.Daily.Int), FormatAmount(spent))
+	}
+	s := Spend{Time: now.Unix()}
+	s.Amount.Set(amount)
+	spends = append(spends, s)
+	data, err := json.Marshal(spends)
+	if err != nil {
+		return err
+	}
+	return stub.PutState(pubKey+SUFFIX_SPEND, data)
+}
+
+//设置用户认证等级, 只允许管理员操作
+func (t *CoinChaincode) setLevel(stub shim.ChaincodeStubInterface, pubKey string, level int) pb.Response {
+	if !isAdmin(stub) {
+		return shim.Error("没有管理员权限")
+	}
+	if level < 0 {
+		return shim.Error("level must not be negative")
+	}
+	checkRs := checkUser(stub, pubKey)
+	if checkRs.GetStatus() != shim.OK {
+		return checkRs
+	}
+	if err := putIntState(stub, pubKey+SUFFIX_LEVEL, level); err != nil {
+		return shim.Error("put fail")
+	}
+	return shim.Success([]byte("ok"))
+}
+
+//设置认证等级的限额, 只允许管理员操作
+func (t *CoinChaincode) setLimit(stub shim.ChaincodeStubInterface, level int, perTx, daily *big.Int) pb.Response {
+	if !isAdmin(stub) {
+		return shim.Error("没有管理员权限")
+	}
+	if level < 0 {
+		return shim.Error("level must not be negative")
+	}
+	limit := &Limit{Level: level}
+	limit.PerTx.Set(perTx)
+	limit.Daily.Set(daily)
+	data, err := json.Marshal(limit)
+	if err != nil {
+		return shim.Error("json error")
+	}
+	key, err := stub.CreateCompositeKey(PRE_KEY_LIMIT, []string{strconv.Itoa(level)})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if err = stub.PutState(key, data); err != nil {
+		return shim.Error("put fail")
+	}
+	return shim.Success([]byte("ok"))
+}
+
+//查询用户限额及24小时内剩余额度, 未配置限额时返回空
+func (t *CoinChaincode) getSpendLimit(stub shim.ChaincodeStubInterface, pubKey string) pb.Response {
+	level, err := getLevel(stub, pubKey)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	limit, err := getLimit(stub, level)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if limit == nil {
+		return shim.Success(nil)
+	}
+	tm, err := stub.GetTxTimestamp()
+	if err != nil {
+		return shim.Error("get timestamp fail")
+	}
+	_, spent, err := getSpends(stub, pubKey, time.Unix(tm.Seconds, 0))
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	status := &LimitStatus{Level: level}
+	status.PerTx.Set(&limit.PerTx.Int)
+	status.Daily.Set(&limit.Daily.Int)
+	status.Spent.Set(spent)
+	if limit.Daily.Sign() > 0 {
+		status.Remaining = &Amount{}
+		if limit.Daily.Cmp(spent) > 0 {
+			status.Remaining.Sub(&limit.Daily.Int, spent)
+		}
+	}
+	data, err := json.Marshal(status)
+	if err != nil {
+		return shim.Error("json error")
+	}
+	return shim.Success(data)
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"fmt"
+	"github.com/golang/protobuf/proto"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+	"github.com/hyperledger/fabric/protos/utils"
+)
+
+/**
+获取客户端提案中调用的链码名称
+链码间调用时返回最外层链码, 例如经trade调用coin时返回trade
+*/
+func getCallerChaincode(stub shim.ChaincodeStubInterface) (string, error) {
+	signedProposal, err := stub.GetSignedProposal()
+	if err != nil {
+		return "", err
+	}
+	if signedProposal == nil {
+		return "", fmt.Errorf("signed proposal is nil")
+	}
+	proposal, err := utils.GetProposal(signedProposal.ProposalBytes)
+	if err != nil {
+		return "", err
+	}
+	payload, err := utils.GetChaincodeProposalPayload(proposal.Payload)
+	if err != nil {
+		return "", err
+	}
+	cis := &pb.ChaincodeInvocationSpec{}
+	err = proto.Unmarshal(payload.Input, cis)
+	if err != nil {
+		return "", err
+	}
+	name := cis.GetChaincodeSpec().GetChaincodeId().GetName()
+	if len(name) == 0 {
+		return "", fmt.Errorf("chaincode name not found in proposal")
+	}
+	return name, nil
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+	"math/big"
+	"strings"
+	"time"
+)
+
+const PRE_KEY_RECEIPT = "coin_receipt"
+const PRE_KEY_RECEIPT_OWNER = "coin_receipt_owner"
+
+const OP_BURN = "burn"
+const OP_REDEEM = "redeem"
+
+//销毁凭证, ID为交易ID
+type Receipt struct {
+	ID      string
+	Type    string
+	Account string
+	Token   string
+	Amount  Amount
+	Reason  string
+	Time    time.Time
+}
+
+func (r *Receipt) toString() []byte {
+	if data, err := json.Marshal(r); err == nil {
+		return data
+	}
+	return []byte("err")
+}
+
+/**
+销毁自己的币
+签名内容为 burn|amount|nonce|reason, 非默认币种为 burn|amount|nonce|symbol|reason
+*/
+func (t *CoinChaincode) burn(stub shim.ChaincodeStubInterface, pubKey, amount_str, reason, nonce, sign, symbol string) pb.Response {
+	return t.destroy(stub, OP_BURN, pubKey, amount_str, reason, nonce, sign, symbol)
+}
+
+/**
+兑付, 用户签名申请且由管理员发起, 兑付的币从流通中销毁
+签名内容为 redeem|amount|nonce|reason, 非默认币种为 redeem|amount|nonce|symbol|reason
+*/
+func (t *CoinChaincode) redeem(stub shim.ChaincodeStubInterface, pubKey, amount_str, reason, nonce, sign, symbol string) pb.Response {
+	if !isAdmin(stub) {
+		return shim.Error("没有管理员权限")
+	}
+	return t.destroy(stub, OP_REDEEM, pubKey, amount_str, reason, nonce, sign, symbol)
+}
+
+func (t *CoinChaincode) destroy(stub shim.ChaincodeStubInterface, op, pubKey, amount_str, reason, nonce, sign, symbol string) pb.Response {
+	//签名信息校验, reason为任意文本, 放在最后
+	payload := []string{op, amount_str, nonce}
+	if symbol != DEFAULT_TOKEN {
+		payload = append(payload, symbol)
+	}
+	payload = append(payload, reason)
+	if !verifyAccount(stub, pubKey, strings.Join(payload, "|"), sign) {
+		return shim.Error("签名验证失败")
+	}
+	amount, err := ParseAmount(amount_str)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if amount.Sign() <= 0 {
+		return shim.Error("amount must be positive")
+	}
+	token, err := mustGetToken(stub, symbol)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if err = token.checkPrecision(amount); err != nil {
+		return shim.Error(err.Error())
+	}
+	//防止重放
+	err = useNonce(stub, pubKey, nonce)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	balance, err := getAmountState(stub, balanceKey(pubKey, symbol))
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	newBalance, err := SubAmount(balance, amount)
+	if err != nil {
+		return shim.Error("balance not enough")
+	}
+	if err = putAmountState(stub, balanceKey(pubKey, symbol), newBalance); err != nil {
+		return shim.Error("put fail")
+	}
+	if err = reduceSupply(stub, symbol, amount); err != nil {
+		return shim.Error(err.Error())
+	}
+	if err = writeJournal(stub, op, symbol, pubKey, ACCOUNT_MINT, amount, stub.GetTxID()); err != nil {
+		return shim.Error(err.Error())
+	}
+	receipt, err := writeReceipt(stub, op, pubKey, symbol, amount, reason)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(receipt.toString())
+}
+
+//减少发行总量
+func reduceSupply(stub shim.ChaincodeStubInterface, symbol string, amount *big.Int) error {
+	total, err := getAmountState(stub, supplyKey(symbol))
+	if err != nil {
+		return err
+	}
+	newTotal, err := SubAmount(total, amount)
+	if err != nil {
+		return fmt.Errorf("total supply not enough")
+	}
+	return putAmountState(stub, supplyKey(symbol), newTotal)
+}
+
+//写入销毁凭证及用户索引
+func writeReceipt(stub shim.ChaincodeStubInterface, op, pubKey, symbol string, amount *big.Int, reason string) (*Receipt, error) {
+	tm, err := stub.GetTxTimestamp()
+	if err != nil {
+		return nil, fmt.Errorf("get timestamp fail")
+	}
+	receipt := &Receipt{ID: stub.GetTxID(), Type: op, Account: pubKey, Token: symbol, Reason: reason, Time: time.Unix(tm.Seconds, 0)}
+	receipt.Amount.Set(amount)
+	key, err := stub.CreateCompositeKey(PRE_KEY_RECEIPT, []string{receipt.ID})
+	if err != nil {
+		return nil, err
+	}
+	ownerKey, err := stub.CreateCompositeKey(PRE_KEY_RECEIPT_OWNER, []string{pubKey, receipt.ID})
+	if err != nil {
+		return nil, err
+	}
+	if err = stub.PutState(key, receipt.toString()); err != nil {
+		return nil, fmt.Errorf("put fail")
+	}
+	if err = stub.PutState(ownerKey, []byte(receipt.ID)); err != nil {
+		return nil, fmt.Errorf("put fail")
+	}
+	return receipt, nil
+}
+
+//查询销毁凭证
+func (t *CoinChaincode) getReceipt(stub shim.ChaincodeStubInterface, receiptID string) pb.Response {
+	key, err := stub.CreateCompositeKey(PRE_KEY_RECEIPT, []string{receiptID})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	b, err := stub.GetState(key)
+	if err != nil {
+		return shim.Error("get data error")
+	}
+	if len(b) == 0 {
+		return shim.Error("receipt not find")
+	}
+	return shim.Success(b)
+}
+
+//查询用户的全部销毁凭证
+func (t *CoinChaincode) getReceipts(stub shim.ChaincodeStubInterface, pubKey string) pb.Response {
+	rs, err := stub.GetStateByPartialCompositeKey(PRE_KEY_RECEIPT_OWNER, []string{pubKey})
+	if err != nil {
+		return shim.Error("系统异常")
+	}
+	defer rs.Close()
+
+	receipts := []json.RawMessage{}
+	for rs.HasNext() {
+		responseRange, err := rs.Next()
+		if err != nil {
+			error_str := fmt.Sprintf("find error: %s", err)
+			fmt.Println(error_str)
+			return shim.Error(error_str)
+		}
+		key, err := stub.CreateCompositeKey(PRE_KEY_RECEIPT, []string{string(responseRange.Value)})
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		b, err := stub.GetState(key)
+		if err != nil {
+			return shim.Error("get data error")
+		}
+		receipts = append(receipts, b)
+	}
+	json_receipts, err := json.Marshal(receipts)
+	if err != nil {
+		return shim.Error("json error")
+	}
+	return shim.Success(json_receipts)
+}
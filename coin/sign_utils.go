@@ -0,0 +1,55 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"math/big"
+)
+
+/**
+验证签名, 签名内容为json原文的sha256摘要
+ecdsa只校验消息的前32字节, 直接签原文时后面的内容不受签名保护
+*/
+func Verify(pubkey, json, sign string) bool {
+	curve := elliptic.P256()
+	pubkeyByte, e := base64.StdEncoding.DecodeString(pubkey)
+	if e != nil {
+		fmt.Println(e)
+	}
+
+	//拆分签名文件
+	r := big.Int{}
+	s := big.Int{}
+	signByte, e := base64.StdEncoding.DecodeString(sign)
+	if e != nil {
+		fmt.Println(e)
+	}
+	sigLen := len(signByte)
+	r.SetBytes(signByte[:(sigLen / 2)])
+	s.SetBytes(signByte[(sigLen / 2):])
+
+	//拆分公钥
+	x := big.Int{}
+	y := big.Int{}
+	keyLen := len(pubkeyByte)
+	x.SetBytes(pubkeyByte[:(keyLen / 2)])
+	y.SetBytes(pubkeyByte[(keyLen / 2):])
+	//还原为原始公钥
+	rawPubKey := ecdsa.PublicKey{Curve: curve, X: &x, Y: &y}
+	//公钥、签名文件、原始数据摘要确认签名有效性
+	digest := sha256.Sum256([]byte(json))
+	if ecdsa.Verify(&rawPubKey, digest[:], &r, &s) == false {
+		return false
+	}
+	return true
+}
+
+/**
+公钥base64解码
+*/
+func PubkeyToBytes(pubkey string) ([]byte, error) {
+	return base64.StdEncoding.DecodeString(pubkey)
+}
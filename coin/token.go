@@ -0,0 +1,122 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"math/big"
+	"regexp"
+	"time"
+)
+
+const PRE_KEY_TOKEN = "coin_token"
+
+//默认币种, 使用原有的余额和发行量key
+const DEFAULT_TOKEN = "COIN"
+
+var symbolPattern = regexp.MustCompile(`^[A-Z][A-Z0-9]{1,9}$`)
+
+/**
+币种定义
+Decimals为金额允许的小数位数, 不能超过AMOUNT_SCALE
+Issuer为允许发行该币种的MSP
+MaxSupply为发行上限, 0表示不限制
+*/
+type Token struct {
+	Symbol     string
+	Name       string
+	Decimals   int
+	Issuer     string
+	MaxSupply  Amount
+	CreateTime time.Time
+}
+
+func (tk *Token) toString() []byte {
+	if data, err := json.Marshal(tk); err == nil {
+		return data
+	}
+	return []byte("err")
+}
+
+//校验金额小数位数不超过币种精度
+func (tk *Token) checkPrecision(amount *big.Int) error {
+	unit := new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(AMOUNT_SCALE-tk.Decimals)), nil)
+	if new(big.Int).Mod(amount, unit).Sign() != 0 {
+		return fmt.Errorf("%s最多支持%d位小数", tk.Symbol, tk.Decimals)
+	}
+	return nil
+}
+
+//读取币种定义, 不存在时返回nil
+func getToken(stub shim.ChaincodeStubInterface, symbol string) (*Token, error) {
+	if symbol == DEFAULT_TOKEN {
+		maxSupply, err := getAmountState(stub, KEY_MAX_SUPPLY)
+		if err != nil {
+			return nil, err
+		}
+		tk := &Token{Symbol: DEFAULT_TOKEN, Name: "Gravity Coin", Decimals: AMOUNT_SCALE}
+		tk.MaxSupply.Set(maxSupply)
+		return tk, nil
+	}
+	key, err := stub.CreateCompositeKey(PRE_KEY_TOKEN, []string{symbol})
+	if err != nil {
+		return nil, err
+	}
+	b, err := stub.GetState(key)
+	if err != nil {
+		return nil, fmt.Errorf("get data error: %s", err)
+	}
+	if len(b) == 0 {
+		return nil, nil
+	}
+	var tk Token
+	err = json.Unmarshal(b, &tk)
+	if err != nil {
+		return nil, fmt.Errorf("token json error: %s", err)
+	}
+	return &tk, nil
+}
+
+func putToken(stub shim.ChaincodeStubInterface, tk *Token) error {
+	key, err := stub.CreateCompositeKey(PRE_KEY_TOKEN, []string{tk.Symbol})
+	if err != nil {
+		return err
+	}
+	return stub.PutState(key, tk.toString())
+}
+
+//读取币种定义, 不存在时报错
+func mustGetToken(stub shim.ChaincodeStubInterface, symbol string) (*Token, error) {
+	tk, err := getToken(stub, symbol)
+	if err != nil {
+		return nil, err
+	}
+	if tk == nil {
+		return nil, fmt.Errorf("token not find: %s", symbol)
+	}
+	return tk, nil
+}
+
+//余额key, 默认币种保持原有格式
+func balanceKey(pubKey, symbol string) string {
+	if symbol == DEFAULT_TOKEN {
+		return pubKey + SUFFIX_COIN
+	}
+	return pubKey + SUFFIX_COIN + "_" + symbol
+}
+
+//发行总量key, 默认币种保持原有格式
+func supplyKey(symbol string) string {
+	if symbol == DEFAULT_TOKEN {
+		return KEY_TOTAL_SUPPLY
+	}
+	return KEY_TOTAL_SUPPLY + "_" + symbol
+}
+
+//可选的币种参数, 未传时为默认币种
+func tokenArg(args []string, index int) string {
+	if len(args) > index && len(args[index]) > 0 {
+		return args[index]
+	}
+	return DEFAULT_TOKEN
+}
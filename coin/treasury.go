@@ -0,0 +1,82 @@
+package main
+
+import (
+	"fmt"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+	"math/big"
+)
+
+const KEY_TREASURY = "coin_treasury"
+const KEY_TREASURY_INCOME = "coin_treasury_income"
+
+//默认平台收入账户
+const ACCOUNT_TREASURY = "@treasury"
+
+const OP_FEE = "fee"
+
+//平台收入账户, 未设置时为默认账户
+func getTreasury(stub shim.ChaincodeStubInterface) (string, error) {
+	b, err := stub.GetState(KEY_TREASURY)
+	if err != nil {
+		return "", fmt.Errorf("get data error: %s", err)
+	}
+	if len(b) == 0 {
+		return ACCOUNT_TREASURY, nil
+	}
+	return string(b), nil
+}
+
+//累计平台收入key, 默认币种不带后缀
+func incomeKey(symbol string) string {
+	if symbol == DEFAULT_TOKEN {
+		return KEY_TREASURY_INCOME
+	}
+	return KEY_TREASURY_INCOME + "_" + symbol
+}
+
+//结算手续费记入平台收入账户
+func collectFee(stub shim.ChaincodeStubInterface, symbol string, fee *big.Int, holdID string) error {
+	treasury, err := getTreasury(stub)
+	if err != nil {
+		return err
+	}
+	if err = creditAccount(stub, symbol, treasury, fee); err != nil {
+		return err
+	}
+	income, err := getAmountState(stub, incomeKey(symbol))
+	if err != nil {
+		return err
+	}
+	newIncome, err := AddAmount(income, fee)
+	if err != nil {
+		return err
+	}
+	if err = putAmountState(stub, incomeKey(symbol), newIncome); err != nil {
+		return err
+	}
+	return writeJournal(stub, OP_FEE, symbol, ACCOUNT_ESCROW, treasury, fee, holdID)
+}
+
+//设置平台收入账户, 只允许管理员操作
+func (t *CoinChaincode) setTreasury(stub shim.ChaincodeStubInterface, account string) pb.Response {
+	if !isAdmin(stub) {
+		return shim.Error("没有管理员权限")
+	}
+	if len(account) == 0 {
+		return shim.Error("account必须填写")
+	}
+	if err := stub.PutState(KEY_TREASURY, []byte(account)); err != nil {
+		return shim.Error("put fail")
+	}
+	return shim.Success([]byte("ok"))
+}
+
+//查询累计平台手续费收入
+func (t *CoinChaincode) treasuryIncome(stub shim.ChaincodeStubInterface, symbol string) pb.Response {
+	income, err := getAmountState(stub, incomeKey(symbol))
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success([]byte(FormatAmount(income)))
+}
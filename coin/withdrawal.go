@@ -0,0 +1,244 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+	"strings"
+	"time"
+)
+
+const PRE_KEY_WITHDRAWAL = "coin_withdrawal"
+const PRE_KEY_WITHDRAWAL_PENDING = "coin_withdrawal_pending"
+
+const OP_WITHDRAW = "withdraw"
+const OP_WITHDRAW_REJECT = "withdrawReject"
+
+const WITHDRAWAL_PENDING = 1
+const WITHDRAWAL_APPROVED = 2
+const WITHDRAWAL_REJECTED = 3
+
+/**
+提现申请, ID为申请交易ID, 同时也是冻结记录ID
+申请时冻结金额, 审核通过后从流通中销毁, 驳回时退回余额
+*/
+type Withdrawal struct {
+	ID         string
+	Account    string
+	Token      string
+	Amount     Amount
+	State      int
+	Reason     string
+	CreateTime time.Time
+	CloseTime  time.Time
+}
+
+func (w *Withdrawal) toString() []byte {
+	if data, err := json.Marshal(w); err == nil {
+		return data
+	}
+	return []byte("err")
+}
+
+//读取提现申请, 不存在时返回nil
+func getWithdrawal(stub shim.ChaincodeStubInterface, id string) (*Withdrawal, error) {
+	key, err := stub.CreateCompositeKey(PRE_KEY_WITHDRAWAL, []string{id})
+	if err != nil {
+		return nil, err
+	}
+	b, err := stub.GetState(key)
+	if err != nil {
+		return nil, fmt.Errorf("get data error: %s", err)
+	}
+	if len(b) == 0 {
+		return nil, nil
+	}
+	var w Withdrawal
+	err = json.Unmarshal(b, &w)
+	if err != nil {
+		return nil, fmt.Errorf("withdrawal json error: %s", err)
+	}
+	return &w, nil
+}
+
+//写入提现申请, 待审核的申请同时写入待审核索引
+func putWithdrawal(stub shim.ChaincodeStubInterface, w *Withdrawal) error {
+	key, err := stub.CreateCompositeKey(PRE_KEY_WITHDRAWAL, []string{w.ID})
+	if err != nil {
+		return err
+	}
+	pendingKey, err := stub.CreateCompositeKey(PRE_KEY_WITHDRAWAL_PENDING, []string{w.ID})
+	if err != nil {
+		return err
+	}
+	if err = stub.PutState(key, w.toString()); err != nil {
+		return err
+	}
+	if w.State == WITHDRAWAL_PENDING {
+		return stub.PutState(pendingKey, []byte(w.ID))
+	}
+	return stub.DelState(pendingKey)
+}
+
+/**
+申请提现, 冻结提现金额等待审核
+签名内容为 withdraw|amount|nonce, 非默认币种为 withdraw|amount|nonce|symbol
+*/
+func (t *CoinChaincode) requestWithdrawal(stub shim.ChaincodeStubInterface, pubKey, amount_str, nonce, sign, symbol string) pb.Response {
+	//签名信息校验
+	payload := []string{OP_WITHDRAW, amount_str, nonce}
+	if symbol != DEFAULT_TOKEN {
+		payload = append(payload, symbol)
+	}
+	if !verifyAccount(stub, pubKey, strings.Join(payload, "|"), sign) {
+		return shim.Error("签名验证失败")
+	}
+	amount, err := ParseAmount(amount_str)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	//防止重放
+	err = useNonce(stub, pubKey, nonce)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	id := stub.GetTxID()
+	holdRs := t.holdCoin(stub, pubKey, amount, id, symbol, false)
+	if holdRs.GetStatus() != shim.OK {
+		return holdRs
+	}
+	tm, err := stub.GetTxTimestamp()
+	if err != nil {
+		return shim.Error("get timestamp fail")
+	}
+	w := &Withdrawal{ID: id, Account: pubKey, Token: symbol, State: WITHDRAWAL_PENDING, CreateTime: time.Unix(tm.Seconds, 0)}
+	w.Amount.Set(amount)
+	if err = putWithdrawal(stub, w); err != nil {
+		return shim.Error("put fail")
+	}
+	return shim.Success([]byte(id))
+}
+
+//读取待审核的提现申请及其冻结记录
+func getPendingWithdrawal(stub shim.ChaincodeStubInterface, id string) (*Withdrawal, *Hold, error) {
+	w, err := getWithdrawal(stub, id)
+	if err != nil {
+		return nil, nil, err
+	}
+	if w == nil {
+		return nil, nil, fmt.Errorf("withdrawal not find")
+	}
+	if w.State != WITHDRAWAL_PENDING {
+		return nil, nil, fmt.Errorf("withdrawal not pending")
+	}
+	hold, err := getHold(stub, id)
+	if err != nil {
+		return nil, nil, err
+	}
+	if hold == nil || hold.State != HOLD_OPEN {
+		return nil, nil, fmt.Errorf("hold not open")
+	}
+	return w, hold, nil
+}
+
+//审核通过, 冻结的币从流通中销毁, ref为线下打款流水号
+func (t *CoinChaincode) approveWithdrawal(stub shim.ChaincodeStubInterface, id, ref string) pb.Response {
+	if !isAdmin(stub) {
+		return shim.Error("没有管理员权限")
+	}
+	w, hold, err := getPendingWithdrawal(stub, id)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	tm, err := stub.GetTxTimestamp()
+	if err != nil {
+		return shim.Error("get timestamp fail")
+	}
+	now := time.Unix(tm.Seconds, 0)
+	hold.State = HOLD_CONFIRM
+	hold.Payee = ACCOUNT_MINT
+	hold.CloseTime = now
+	w.State = WITHDRAWAL_APPROVED
+	w.Reason = ref
+	w.CloseTime = now
+
+	//更新数据
+	if err = reduceSupply(stub, w.Token, &w.Amount.Int); err != nil {
+		return shim.Error(err.Error())
+	}
+	if err = putHold(stub, hold); err != nil {
+		return shim.Error("put fail")
+	}
+	if err = putWithdrawal(stub, w); err != nil {
+		return shim.Error("put fail")
+	}
+	if err = writeJournal(stub, OP_WITHDRAW, w.Token, ACCOUNT_ESCROW, ACCOUNT_MINT, &w.Amount.Int, id); err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(w.toString())
+}
+
+//驳回提现申请, 冻结的币退回用户余额
+func (t *CoinChaincode) rejectWithdrawal(stub shim.ChaincodeStubInterface, id, reason string) pb.Response {
+	if !isAdmin(stub) {
+		return shim.Error("没有管理员权限")
+	}
+	w, hold, err := getPendingWithdrawal(stub, id)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if err = refundHold(stub, hold, HOLD_RELEASE, OP_WITHDRAW_REJECT); err != nil {
+		return shim.Error(err.Error())
+	}
+	w.State = WITHDRAWAL_REJECTED
+	w.Reason = reason
+	w.CloseTime = hold.CloseTime
+	if err = putWithdrawal(stub, w); err != nil {
+		return shim.Error("put fail")
+	}
+	return shim.Success(w.toString())
+}
+
+//查询提现申请
+func (t *CoinChaincode) getWithdrawal(stub shim.ChaincodeStubInterface, id string) pb.Response {
+	w, err := getWithdrawal(stub, id)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if w == nil {
+		return shim.Error("withdrawal not find")
+	}
+	return shim.Success(w.toString())
+}
+
+//查询全部待审核的提现申请
+func (t *CoinChaincode) getPendingWithdrawals(stub shim.ChaincodeStubInterface) pb.Response {
+	rs, err := stub.GetStateByPartialCompositeKey(PRE_KEY_WITHDRAWAL_PENDING, []string{})
+	if err != nil {
+		return shim.Error("系统异常")
+	}
+	defer rs.Close()
+
+	withdrawals := []*Withdrawal{}
+	for rs.HasNext() {
+		responseRange, err := rs.Next()
+		if err != nil {
+			error_str := fmt.Sprintf("find error: %s", err)
+			fmt.Println(error_str)
+			return shim.Error(error_str)
+		}
+		w, err := getWithdrawal(stub, string(responseRange.Value))
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		if w != nil {
+			withdrawals = append(withdrawals, w)
+		}
+	}
+	json_withdrawals, err := json.Marshal(withdrawals)
+	if err != nil {
+		return shim.Error("json error")
+	}
+	return shim.Success(json_withdrawals)
+}
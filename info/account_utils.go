@@ -0,0 +1,28 @@
+package main
+
+import (
+	"fmt"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+/**
+账户ID为首次注册时的公钥, 换钥后账户ID不变
+签名需使用user合约中登记的账户当前公钥校验
+*/
+func currentKey(stub shim.ChaincodeStubInterface, account string) (string, error) {
+	rs := stub.InvokeChaincode("user", [][]byte{[]byte("getKey"), []byte(account)}, "")
+	if rs.GetStatus() != shim.OK {
+		return "", fmt.Errorf("%s", rs.GetMessage())
+	}
+	return string(rs.GetPayload()), nil
+}
+
+//使用账户当前公钥验证签名
+func verifyAccount(stub shim.ChaincodeStubInterface, account, payload, sign string) bool {
+	key, err := currentKey(stub, account)
+	if err != nil {
+		fmt.Println(err)
+		return false
+	}
+	return Verify(key, payload, sign)
+}
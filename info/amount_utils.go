@@ -0,0 +1,142 @@
+package main
+
+import (
+	"fmt"
+	"math/big"
+	"strings"
+)
+
+//金额小数位数, 金额按最小单位的整数计算, 如1.00记为100
+const AMOUNT_SCALE = 2
+
+//金额上限位数, 最小单位的整数不能超过10^30
+const AMOUNT_MAX_DIGITS = 30
+
+var amountMax = new(big.Int).Exp(big.NewInt(10), big.NewInt(AMOUNT_MAX_DIGITS), nil)
+
+/**
+金额, JSON中以十进制字符串表示, 如"12.50"
+兼容原有的整数格式, 如 100 或 "100"
+*/
+type Amount struct {
+	big.Int
+}
+
+func (a Amount) MarshalJSON() ([]byte, error) {
+	return []byte(`"` + FormatAmount(&a.Int) + `"`), nil
+}
+
+func (a *Amount) UnmarshalJSON(data []byte) error {
+	v, err := parseDecimal(strings.Trim(string(data), `"`), AMOUNT_SCALE)
+	if err != nil {
+		return err
+	}
+	a.Set(v)
+	return nil
+}
+
+/**
+解析金额, 返回最小单位的整数, 如"12.5"返回1250
+不带小数点的旧整数格式按整币处理, 如"100"返回10000
+*/
+func ParseAmount(str string) (*big.Int, error) {
+	v, err := parseDecimal(str, AMOUNT_SCALE)
+	if err != nil {
+		return nil, err
+	}
+	if err = CheckAmount(v); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+/**
+金额格式化为十进制字符串, 如1250返回"12.50"
+*/
+func FormatAmount(v *big.Int) string {
+	return formatDecimal(v, AMOUNT_SCALE)
+}
+
+/**
+校验金额不为负数且不超过上限
+*/
+func CheckAmount(v *big.Int) error {
+	if v.Sign() < 0 {
+		return fmt.Errorf("amount must not be negative")
+	}
+	if v.Cmp(amountMax) >= 0 {
+		return fmt.Errorf("amount overflow")
+	}
+	return nil
+}
+
+/**
+金额相加, 结果超过上限时报错
+*/
+func AddAmount(a, b *big.Int) (*big.Int, error) {
+	v := new(big.Int).Add(a, b)
+	if err := CheckAmount(v); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+/**
+金额相减, 结果为负数时报错
+*/
+func SubAmount(a, b *big.Int) (*big.Int, error) {
+	v := new(big.Int).Sub(a, b)
+	if err := CheckAmount(v); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+func parseDecimal(str string, scale int) (*big.Int, error) {
+	s := strings.TrimSpace(str)
+	neg := strings.HasPrefix(s, "-")
+	if neg {
+		s = s[1:]
+	}
+	intPart := s
+	fracPart := ""
+	if i := strings.Index(s, "."); i >= 0 {
+		intPart = s[:i]
+		fracPart = s[i+1:]
+		if len(fracPart) == 0 {
+			return nil, fmt.Errorf("金额格式错误: %s", str)
+		}
+	}
+	if len(intPart) == 0 || len(intPart) > AMOUNT_MAX_DIGITS || len(fracPart) > scale {
+		return nil, fmt.Errorf("金额格式错误: %s", str)
+	}
+	digits := intPart + fracPart + strings.Repeat("0", scale-len(fracPart))
+	for _, c := range digits {
+		if c < '0' || c > '9' {
+			return nil, fmt.Errorf("金额格式错误: %s", str)
+		}
+	}
+	v, ok := new(big.Int).SetString(digits, 10)
+	if !ok {
+		return nil, fmt.Errorf("金额格式错误: %s", str)
+	}
+	if neg {
+		v.Neg(v)
+	}
+	return v, nil
+}
+
+func formatDecimal(v *big.Int, scale int) string {
+	digits := new(big.Int).Abs(v).String()
+	if len(digits) <= scale {
+		digits = strings.Repeat("0", scale-len(digits)+1) + digits
+	}
+	str := digits
+	if scale > 0 {
+		str = digits[:len(digits)-scale] + "." + digits[len(digits)-scale:]
+	}
+	if v.Sign() < 0 {
+		str = "-" + str
+	}
+	return str
+}
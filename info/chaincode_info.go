@@ -11,14 +11,15 @@ import (
 const INIT_COIN = 100
 const PRE_KEY = "info_"
 
-//peer chaincode invoke -C mychannel -n info -c '{"Function":"set","Args":["pubkey1","{\"Title\":\"banjia\",\"Content\":\"上门搬家服务\",\"Price\":10,\"City\":\"Beijing\"}","sign"]}'
+//peer chaincode invoke -C mychannel -n info -c '{"Function":"set","Args":["pubkey1","{\"Title\":\"banjia\",\"Content\":\"上门搬家服务\",\"Price\":10,\"City\":\"Beijing\"}","1","sign"]}'
 // type Info struct {
 // 	PubKey      string
 // 	Title       string
 // 	Content     string
 // 	CompanyName string
 // 	City        string
-// 	Price       int
+// 	Category    string
+// 	Price       Amount
 // 	PublishTime time.Time
 // }
 
@@ -38,10 +39,19 @@ func (t *InfoChaincode) Invoke(stub shim.ChaincodeStubInterface) pb.Response {
 		}
 		return t.get(stub, args[0])
 	} else if function == "set" {
-		if len(args) != 3 {
-			return shim.Error("Incorrect num of args, excepting 3")
+		if len(args) != 4 {
+			return shim.Error("Incorrect num of args, excepting 4")
+		}
+		return t.set(stub, args[0], args[1], args[2], args[3])
+	} else if function == "getNonce" {
+		if len(args) != 1 {
+			return shim.Error("Incorrect num of args, excepting 1")
+		}
+		nonce, err := getNonce(stub, args[0])
+		if err != nil {
+			return shim.Error(err.Error())
 		}
-		return t.set(stub, args[0], args[1], args[2])
+		return shim.Success([]byte(strconv.Itoa(nonce)))
 	} else if function == "getByOwner" {
 		if len(args) != 1 {
 			return shim.Error("Incorrect num of args, excepting 1")
@@ -57,11 +67,16 @@ func (t *InfoChaincode) Invoke(stub shim.ChaincodeStubInterface) pb.Response {
 	return shim.Error(error_str)
 }
 
-func (t *InfoChaincode) set(stub shim.ChaincodeStubInterface, pubKey string, info_str string, sign string) pb.Response {
+//签名内容为 set|info_str|nonce
+func (t *InfoChaincode) set(stub shim.ChaincodeStubInterface, pubKey string, info_str string, nonce string, sign string) pb.Response {
 	//签名信息校验
-	if !Verify(pubKey, info_str, sign) {
+	if !verifyAccount(stub, pubKey, "set|"+info_str+"|"+nonce, sign) {
 		return shim.Error("签名验证失败")
 	}
+	//防止重放
+	if err := useNonce(stub, pubKey, nonce); err != nil {
+		return shim.Error(err.Error())
+	}
 	checkResponse := stub.InvokeChaincode("check_info", [][]byte{[]byte("check"), []byte(pubKey), []byte(info_str)}, "")
 	//用户信息校验
 	if checkResponse.GetStatus() != shim.OK {
@@ -112,10 +127,16 @@ func (t *InfoChaincode) getByOwner(stub shim.ChaincodeStubInterface, pubKey stri
 
 func (t *InfoChaincode) matching(stub shim.ChaincodeStubInterface, mc_id, city, price_lower, price_upper string) pb.Response {
 
-	lower, err := strconv.Atoi(price_lower)
-	upper, err := strconv.Atoi(price_upper)
-	if lower > upper || lower < 0 {
-		return shim.Error(fmt.Sprintf("价格输入有误 %s, %s", lower, upper))
+	lower, err := ParseAmount(price_lower)
+	if err != nil {
+		return shim.Error(fmt.Sprintf("价格输入有误 %s", price_lower))
+	}
+	upper, err := ParseAmount(price_upper)
+	if err != nil {
+		return shim.Error(fmt.Sprintf("价格输入有误 %s", price_upper))
+	}
+	if lower.Cmp(upper) > 0 {
+		return shim.Error(fmt.Sprintf("价格输入有误 %s, %s", price_lower, price_upper))
 	}
 
 	rs, err := stub.GetStateByPartialCompositeKey(PRE_KEY, []string{})
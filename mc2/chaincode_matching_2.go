@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"github.com/hyperledger/fabric/core/chaincode/shim"
 	pb "github.com/hyperledger/fabric/protos/peer"
+	"math/big"
 	"sort"
 	"strconv"
 	"strings"
@@ -23,7 +24,8 @@ type Info struct {
 	Content     string
 	CompanyName string
 	City        string
-	Price       int
+	Category    string
+	Price       Amount
 	PublishTime time.Time
 }
 
@@ -35,7 +37,7 @@ func (infos Infos) Len() int {
 
 //根据价格排序
 func (infos Infos) Less(i, j int) bool {
-	return infos[i].Price < infos[j].Price
+	return infos[i].Price.Cmp(&infos[j].Price.Int) < 0
 }
 func (infos Infos) Swap(i, j int) {
 	infos[i], infos[j] = infos[j], infos[i]
@@ -62,18 +64,21 @@ func (t *D2_MatchingChaincode) Invoke(stub shim.ChaincodeStubInterface) pb.Respo
 	_, args := stub.GetFunctionAndParameters()
 	infos_str := args[0]
 	city := args[1]
-	price_lower, err := strconv.Atoi(args[2])
-	price_upper, err := strconv.Atoi(args[3])
-	if price_lower > price_upper || price_lower < 0 {
-		return shim.Error(fmt.Sprintf("价格输入有误 %s, %s", price_lower, price_upper))
+	price_lower, err := ParseAmount(args[2])
+	if err != nil {
+		return shim.Error("price参数格式异常")
 	}
+	price_upper, err := ParseAmount(args[3])
 	if err != nil {
-		return shim.Error("price参数转换为int类型异常")
+		return shim.Error("price参数格式异常")
+	}
+	if price_lower.Cmp(price_upper) > 0 {
+		return shim.Error(fmt.Sprintf("价格输入有误 %s, %s", args[2], args[3]))
 	}
 	return t.matching(stub, infos_str, city, price_lower, price_upper)
 }
 
-func (t *D2_MatchingChaincode) matching(stub shim.ChaincodeStubInterface, infos_str, city string, price_lower, price_upper int) pb.Response {
+func (t *D2_MatchingChaincode) matching(stub shim.ChaincodeStubInterface, infos_str, city string, price_lower, price_upper *big.Int) pb.Response {
 	var infos Infos
 	var info_map = make(map[string]string)
 
@@ -91,7 +96,7 @@ func (t *D2_MatchingChaincode) matching(stub shim.ChaincodeStubInterface, infos_
 			fmt.Println(error_str)
 			return shim.Error(error_str)
 		}
-		if strings.EqualFold(info.City, city) && info.Price >= price_lower && info.Price <= price_upper {
+		if strings.EqualFold(info.City, city) && info.Price.Cmp(price_lower) >= 0 && info.Price.Cmp(price_upper) <= 0 {
 			info.ID = k
 			infos = append(infos, info)
 		}
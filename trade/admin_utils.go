@@ -0,0 +1,35 @@
+package main
+
+import (
+	"github.com/hyperledger/fabric/core/chaincode/lib/cid"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+const KEY_ADMIN_MSP = "trade_admin_msp"
+const DEFAULT_ADMIN_MSP = "Org1MSP"
+
+//管理员证书需包含该属性且值为true
+const ADMIN_ATTR = "gravity.admin"
+
+/**
+校验交易发起者是否为管理员
+发起者需属于管理员MSP, 且证书带有管理员属性
+*/
+func isAdmin(stub shim.ChaincodeStubInterface) bool {
+	mspID, err := cid.GetMSPID(stub)
+	if err != nil {
+		return false
+	}
+	adminMSP, err := stub.GetState(KEY_ADMIN_MSP)
+	if err != nil {
+		return false
+	}
+	if len(adminMSP) == 0 {
+		adminMSP = []byte(DEFAULT_ADMIN_MSP)
+	}
+	if mspID != string(adminMSP) {
+		return false
+	}
+	return cid.AssertAttributeValue(stub, ADMIN_ATTR, "true") == nil
+}
+
@@ -5,7 +5,9 @@ import (
 	"fmt"
 	"github.com/hyperledger/fabric/core/chaincode/shim"
 	pb "github.com/hyperledger/fabric/protos/peer"
+	"math/big"
 	"strconv"
+	"strings"
 	"time"
 )
 
@@ -21,7 +23,8 @@ type Info struct {
 	Content     string
 	CompanyName string
 	City        string
-	Price       int
+	Category    string
+	Price       Amount
 	PublishTime time.Time
 }
 
@@ -29,45 +32,94 @@ type Trade struct {
 	Constumer   string
 	Business    string
 	InfoID      string
+	HoldID      string
 	Title       string
-	Price       int
+	City        string
+	Category    string
+	Price       Amount
+	Fee         Amount
 	SubmitTime  time.Time
 	ConfirmTime time.Time
 	FinishTIme  time.Time
+	CancelTime  time.Time
 	State       int
 }
 
 const STATE_SUBMIT = 1
 const STATE_CONFIRM = 2
 const STATE_FINISH = 3
+const STATE_CANCEL = 4
 const PRE_KEY_C = "trade_c"
 const PRE_KEY_B = "trade_c"
+const PRE_KEY_FEE = "trade_fee"
+
+//coin合约中冻结记录过期被退回的状态
+const HOLD_EXPIRE = 4
+
+//手续费费率以万分之一为单位
+const FEE_SCOPE_DEFAULT = "default"
+const FEE_BPS_BASE = 10000
 
 type TradeChaincode struct {
 }
 
+//初始化参数: [管理员MSP], 不传时保留原有配置
 func (t *TradeChaincode) Init(stub shim.ChaincodeStubInterface) pb.Response {
-
+	_, args := stub.GetFunctionAndParameters()
+	if len(args) > 0 && len(args[0]) > 0 {
+		err := stub.PutState(KEY_ADMIN_MSP, []byte(args[0]))
+		if err != nil {
+			return shim.Error("写入数据失败")
+		}
+	}
 	return shim.Success([]byte("ok"))
 }
 
 func (t *TradeChaincode) Invoke(stub shim.ChaincodeStubInterface) pb.Response {
 	function, args := stub.GetFunctionAndParameters()
 	if function == "submit" {
-		if len(args) != 3 {
-			return shim.Error("Incorrect num of args, excepting 3")
+		if len(args) != 4 {
+			return shim.Error("Incorrect num of args, excepting 4")
 		}
-		return t.submit(stub, args[0], args[1], args[2])
+		return t.submit(stub, args[0], args[1], args[2], args[3])
 	} else if function == "confirm" {
-		if len(args) != 3 {
-			return shim.Error("Incorrect num of args, excepting 3")
+		if len(args) != 4 {
+			return shim.Error("Incorrect num of args, excepting 4")
 		}
-		return t.confirm(stub, args[0], args[1], args[2])
+		return t.confirm(stub, args[0], args[1], args[2], args[3])
 	} else if function == "finish" {
-		if len(args) != 3 {
-			return shim.Error("Incorrect num of args, excepting 3")
+		if len(args) != 4 {
+			return shim.Error("Incorrect num of args, excepting 4")
+		}
+		return t.finish(stub, args[0], args[1], args[2], args[3])
+	} else if function == "cancel" {
+		if len(args) != 4 {
+			return shim.Error("Incorrect num of args, excepting 4")
+		}
+		return t.cancel(stub, args[0], args[1], args[2], args[3])
+	} else if function == "getNonce" {
+		if len(args) != 1 {
+			return shim.Error("Incorrect num of args, excepting 1")
+		}
+		nonce, err := getNonce(stub, args[0])
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		return shim.Success([]byte(strconv.Itoa(nonce)))
+	} else if function == "setFeeRate" {
+		if len(args) != 2 {
+			return shim.Error("Incorrect num of args, excepting 2")
+		}
+		bps, err := strconv.Atoi(args[1])
+		if err != nil {
+			return shim.Error("参数转换为int类型异常")
 		}
-		return t.finish(stub, args[0], args[1], args[2])
+		return t.setFeeRate(stub, args[0], bps)
+	} else if function == "getFeeRate" {
+		if len(args) != 1 {
+			return shim.Error("Incorrect num of args, excepting 1")
+		}
+		return t.getFeeRate(stub, args[0])
 	} else if function == "getTradeByConstumer" {
 		if len(args) != 1 {
 			return shim.Error("Incorrect num of args, excepting 1")
@@ -82,10 +134,15 @@ func (t *TradeChaincode) Invoke(stub shim.ChaincodeStubInterface) pb.Response {
 	return shim.Error("function error")
 }
 
-func (t *TradeChaincode) submit(stub shim.ChaincodeStubInterface, pubKey, infoId, sign string) pb.Response {
-	if !Verify(pubKey, infoId, sign) {
+//签名内容为 submit|infoId|nonce
+func (t *TradeChaincode) submit(stub shim.ChaincodeStubInterface, pubKey, infoId, nonce, sign string) pb.Response {
+	if !verifyAccount(stub, pubKey, "submit|"+infoId+"|"+nonce, sign) {
 		return shim.Error("签名验证失败")
 	}
+	//防止重放
+	if err := useNonce(stub, pubKey, nonce); err != nil {
+		return shim.Error(err.Error())
+	}
 	infoResponse := stub.InvokeChaincode("info", [][]byte{[]byte("get"), []byte(infoId)}, "")
 	if infoResponse.GetStatus() != shim.OK {
 		return infoResponse
@@ -99,14 +156,27 @@ func (t *TradeChaincode) submit(stub shim.ChaincodeStubInterface, pubKey, infoId
 	if err != nil {
 		return shim.Error("json error")
 	}
-	coinRs := stub.InvokeChaincode("coin", [][]byte{[]byte("freeze"), []byte(pubKey), []byte(strconv.Itoa(info.Price))}, "")
-	if coinRs.Status != shim.OK {
-		return coinRs
+	//买卖双方都不能是停用的账户
+	for _, account := range []string{pubKey, info.PubKey} {
+		activeRs := stub.InvokeChaincode("user", [][]byte{[]byte("checkActive"), []byte(account)}, "")
+		if activeRs.GetStatus() != shim.OK {
+			return activeRs
+		}
+	}
+	//买家需先在coin合约中授权trade合约动用足够的额度, 免费信息不冻结
+	if info.Price.Sign() > 0 {
+		coinRs := stub.InvokeChaincode("coin", [][]byte{[]byte("freezeFrom"), []byte(pubKey), []byte(FormatAmount(&info.Price.Int)), []byte(stub.GetTxID())}, "")
+		if coinRs.Status != shim.OK {
+			return coinRs
+		}
 	}
 	trade := &Trade{}
 	trade.Constumer = pubKey
 	trade.InfoID = infoId
+	trade.HoldID = stub.GetTxID()
 	trade.Title = info.Title
+	trade.City = info.City
+	trade.Category = info.Category
 	trade.Business = info.PubKey
 	tm, err := stub.GetTxTimestamp()
 	trade.SubmitTime = time.Unix(tm.Seconds, 0)
@@ -121,13 +191,21 @@ func (t *TradeChaincode) submit(stub shim.ChaincodeStubInterface, pubKey, infoId
 	if err != nil {
 		return shim.Error("写入数据失败")
 	}
+	if err = emitTradeEvent(stub, "submit", trade); err != nil {
+		return shim.Error("set event fail")
+	}
 	return shim.Success([]byte("ok"))
 }
 
-func (t *TradeChaincode) confirm(stub shim.ChaincodeStubInterface, pubKey, tradeID, sign string) pb.Response {
-	if !Verify(pubKey, tradeID, sign) {
+//签名内容为 confirm|tradeID|nonce
+func (t *TradeChaincode) confirm(stub shim.ChaincodeStubInterface, pubKey, tradeID, nonce, sign string) pb.Response {
+	if !verifyAccount(stub, pubKey, "confirm|"+tradeID+"|"+nonce, sign) {
 		return shim.Error("签名验证失败")
 	}
+	//防止重放
+	if err := useNonce(stub, pubKey, nonce); err != nil {
+		return shim.Error(err.Error())
+	}
 	trade_str, err := stub.GetState(tradeID)
 	if err != nil {
 		return shim.Error("query error")
@@ -139,6 +217,10 @@ func (t *TradeChaincode) confirm(stub shim.ChaincodeStubInterface, pubKey, trade
 	if err != nil {
 		return shim.Error("json error")
 	}
+	//只有卖家可以确认
+	if pubKey != trade.Business {
+		return shim.Error("no permission")
+	}
 	if trade.State != STATE_SUBMIT {
 		return shim.Error("state not submit")
 	}
@@ -159,10 +241,15 @@ func (t *TradeChaincode) confirm(stub shim.ChaincodeStubInterface, pubKey, trade
 	return shim.Success([]byte("ok"))
 }
 
-func (t *TradeChaincode) finish(stub shim.ChaincodeStubInterface, pubKey, tradeID, sign string) pb.Response {
-	if !Verify(pubKey, tradeID, sign) {
+//签名内容为 finish|tradeID|nonce
+func (t *TradeChaincode) finish(stub shim.ChaincodeStubInterface, pubKey, tradeID, nonce, sign string) pb.Response {
+	if !verifyAccount(stub, pubKey, "finish|"+tradeID+"|"+nonce, sign) {
 		return shim.Error("签名验证失败")
 	}
+	//防止重放
+	if err := useNonce(stub, pubKey, nonce); err != nil {
+		return shim.Error(err.Error())
+	}
 	trade_str, err := stub.GetState(tradeID)
 	if err != nil {
 		return shim.Error("query error")
@@ -174,15 +261,33 @@ func (t *TradeChaincode) finish(stub shim.ChaincodeStubInterface, pubKey, tradeI
 	if err != nil {
 		return shim.Error("json error")
 	}
+	//只有买家可以确认完成并付款
+	if pubKey != trade.Constumer {
+		return shim.Error("no permission")
+	}
 	if trade.State != STATE_CONFIRM {
 		return shim.Error("state not submit")
 	}
-	coinRs := stub.InvokeChaincode("coin", [][]byte{[]byte("confirm"), []byte(trade.Constumer), []byte(trade.Business), []byte(strconv.Itoa(trade.Price))}, "")
-	if coinRs.Status != shim.OK {
-		return coinRs
+	//按费率计算平台手续费, 结算时从货款中扣除
+	bps, err := feeRateFor(stub, trade.City, trade.Category)
+	if err != nil {
+		return shim.Error(err.Error())
 	}
-
+	fee := new(big.Int).Mul(&trade.Price.Int, big.NewInt(int64(bps)))
+	fee.Quo(fee, big.NewInt(FEE_BPS_BASE))
+	trade.Fee.Set(fee)
 	_, attrArray, _ := stub.SplitCompositeKey(tradeID)
+	//旧交易没有HoldID, 冻结记录以提交交易ID为key, 由管理员通过coin合约migrateHold迁移
+	if len(trade.HoldID) == 0 {
+		trade.HoldID = attrArray[1]
+	}
+	if trade.Price.Sign() > 0 {
+		coinRs := stub.InvokeChaincode("coin", [][]byte{[]byte("confirm"), []byte(trade.HoldID), []byte(trade.Business), []byte(FormatAmount(fee))}, "")
+		if coinRs.Status != shim.OK {
+			return coinRs
+		}
+	}
+
 	var tradeID_B, _ = stub.CreateCompositeKey(PRE_KEY_B, []string{trade.Business, attrArray[1]})
 
 	trade.State = STATE_FINISH
@@ -196,9 +301,173 @@ func (t *TradeChaincode) finish(stub shim.ChaincodeStubInterface, pubKey, tradeI
 		return shim.Error("写入数据失败")
 	}
 
+	if err = emitTradeEvent(stub, "finish", &trade); err != nil {
+		return shim.Error("set event fail")
+	}
 	return shim.Success([]byte("ok"))
 }
 
+//取消交易并退回冻结的币
+//买家只能取消未确认的交易, 卖家可以拒绝未完成的交易, 冻结过期被退回后双方都可以关闭交易
+//签名内容为 cancel|tradeID|nonce
+func (t *TradeChaincode) cancel(stub shim.ChaincodeStubInterface, pubKey, tradeID, nonce, sign string) pb.Response {
+	if !verifyAccount(stub, pubKey, "cancel|"+tradeID+"|"+nonce, sign) {
+		return shim.Error("签名验证失败")
+	}
+	//防止重放
+	if err := useNonce(stub, pubKey, nonce); err != nil {
+		return shim.Error(err.Error())
+	}
+	trade_str, err := stub.GetState(tradeID)
+	if err != nil {
+		return shim.Error("query error")
+	}
+	if len(trade_str) <= 0 {
+		return shim.Error("trade not find")
+	}
+	trade, err := jsonToTrade(string(trade_str))
+	if err != nil {
+		return shim.Error("json error")
+	}
+	_, attrArray, _ := stub.SplitCompositeKey(tradeID)
+	if len(trade.HoldID) == 0 {
+		trade.HoldID = attrArray[1]
+	}
+	//冻结已过期并被退回时, 买卖双方都可以关闭未完成的交易
+	reclaimed := false
+	if trade.Price.Sign() > 0 {
+		reclaimed, err = isHoldReclaimed(stub, trade.HoldID)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+	}
+	if pubKey == trade.Constumer && !reclaimed {
+		if trade.State != STATE_SUBMIT {
+			return shim.Error("state not submit")
+		}
+	} else if pubKey == trade.Business || pubKey == trade.Constumer {
+		if trade.State != STATE_SUBMIT && trade.State != STATE_CONFIRM {
+			return shim.Error("state not submit or confirm")
+		}
+	} else {
+		return shim.Error("no permission")
+	}
+	if trade.Price.Sign() > 0 && !reclaimed {
+		coinRs := stub.InvokeChaincode("coin", [][]byte{[]byte("release"), []byte(trade.HoldID)}, "")
+		if coinRs.Status != shim.OK {
+			return coinRs
+		}
+	}
+
+	var tradeID_C, _ = stub.CreateCompositeKey(PRE_KEY_C, []string{trade.Constumer, attrArray[1]})
+	var tradeID_B, _ = stub.CreateCompositeKey(PRE_KEY_B, []string{trade.Business, attrArray[1]})
+
+	trade.State = STATE_CANCEL
+	tm, err := stub.GetTxTimestamp()
+	trade.CancelTime = time.Unix(tm.Seconds, 0)
+
+	err = stub.PutState(tradeID_C, trade.toString())
+	if err != nil {
+		return shim.Error("写入数据失败")
+	}
+	err = stub.PutState(tradeID_B, trade.toString())
+	if err != nil {
+		return shim.Error("写入数据失败")
+	}
+
+	if err = emitTradeEvent(stub, "cancel", &trade); err != nil {
+		return shim.Error("set event fail")
+	}
+	return shim.Success([]byte("ok"))
+}
+
+//冻结记录是否已过期并由reclaimExpired退回
+func isHoldReclaimed(stub shim.ChaincodeStubInterface, holdID string) (bool, error) {
+	coinRs := stub.InvokeChaincode("coin", [][]byte{[]byte("getHold"), []byte(holdID)}, "")
+	if coinRs.Status != shim.OK {
+		return false, fmt.Errorf("%s", coinRs.GetMessage())
+	}
+	var hold struct {
+		State int
+	}
+	if err := json.Unmarshal(coinRs.GetPayload(), &hold); err != nil {
+		return false, fmt.Errorf("hold json error: %s", err)
+	}
+	return hold.State == HOLD_EXPIRE, nil
+}
+
+//设置手续费费率, scope为default、city:<城市>或category:<分类>, 只允许管理员操作
+func (t *TradeChaincode) setFeeRate(stub shim.ChaincodeStubInterface, scope string, bps int) pb.Response {
+	if !isAdmin(stub) {
+		return shim.Error("没有管理员权限")
+	}
+	if scope != FEE_SCOPE_DEFAULT && !strings.HasPrefix(scope, "city:") && !strings.HasPrefix(scope, "category:") {
+		return shim.Error("scope格式错误")
+	}
+	if bps < 0 || bps > FEE_BPS_BASE {
+		return shim.Error("费率必须在0到10000之间")
+	}
+	key, err := stub.CreateCompositeKey(PRE_KEY_FEE, []string{scope})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = stub.PutState(key, []byte(strconv.Itoa(bps)))
+	if err != nil {
+		return shim.Error("写入数据失败")
+	}
+	return shim.Success([]byte("ok"))
+}
+
+func (t *TradeChaincode) getFeeRate(stub shim.ChaincodeStubInterface, scope string) pb.Response {
+	bps, _, err := getFeeRate(stub, scope)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success([]byte(strconv.Itoa(bps)))
+}
+
+//读取费率, 未配置时返回false
+func getFeeRate(stub shim.ChaincodeStubInterface, scope string) (int, bool, error) {
+	key, err := stub.CreateCompositeKey(PRE_KEY_FEE, []string{scope})
+	if err != nil {
+		return 0, false, err
+	}
+	b, err := stub.GetState(key)
+	if err != nil {
+		return 0, false, fmt.Errorf("query error: %s", err)
+	}
+	if len(b) == 0 {
+		return 0, false, nil
+	}
+	bps, err := strconv.Atoi(string(b))
+	if err != nil {
+		return 0, false, fmt.Errorf("fee rate error: %s", b)
+	}
+	return bps, true, nil
+}
+
+//交易适用的费率, 优先级为 分类 > 城市 > 默认
+func feeRateFor(stub shim.ChaincodeStubInterface, city, category string) (int, error) {
+	scopes := []string{}
+	if len(category) > 0 {
+		scopes = append(scopes, "category:"+category)
+	}
+	if len(city) > 0 {
+		scopes = append(scopes, "city:"+city)
+	}
+	scopes = append(scopes, FEE_SCOPE_DEFAULT)
+	for _, scope := range scopes {
+		bps, ok, err := getFeeRate(stub, scope)
+		if err != nil {
+			return 0, err
+		}
+		if ok {
+			return bps, nil
+		}
+	}
+	return 0, nil
+}
+
 func (t *TradeChaincode) getTradeByConstumer(stub shim.ChaincodeStubInterface, pubKey string) pb.Response {
 	rs, err := stub.GetStateByPartialCompositeKey(PRE_KEY_C, []string{pubKey})
 	if err != nil {
@@ -249,6 +518,30 @@ func (t *TradeChaincode) getTradeByBusiness(stub shim.ChaincodeStubInterface, pu
 	return shim.Success(json_trades)
 }
 
+//资金相关的交易事件, 事件名为 trade.<function>
+//coin合约经链码间调用产生的事件不会发送给客户端, 由trade合约发送
+type TradeEvent struct {
+	TradeID   string
+	Function  string
+	Constumer string
+	Business  string
+	InfoID    string
+	Price     Amount
+	Fee       Amount
+	State     int
+}
+
+func emitTradeEvent(stub shim.ChaincodeStubInterface, function string, trade *Trade) error {
+	e := &TradeEvent{TradeID: trade.HoldID, Function: function, Constumer: trade.Constumer, Business: trade.Business, InfoID: trade.InfoID, State: trade.State}
+	e.Price.Set(&trade.Price.Int)
+	e.Fee.Set(&trade.Fee.Int)
+	payload, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	return stub.SetEvent("trade."+function, payload)
+}
+
 func jsonToInfo(str string) (Info, error) {
 	var i Info
 	err := json.Unmarshal([]byte(str), &i)
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"fmt"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"strconv"
+)
+
+const SUFFIX_NONCE = "_trade_nonce"
+
+//账户已使用的最大nonce, 未使用过时为0
+func getNonce(stub shim.ChaincodeStubInterface, account string) (int, error) {
+	b, err := stub.GetState(account + SUFFIX_NONCE)
+	if err != nil {
+		return 0, fmt.Errorf("get data error: %s", err)
+	}
+	if len(b) == 0 {
+		return 0, nil
+	}
+	nonce, err := strconv.Atoi(string(b))
+	if err != nil {
+		return 0, fmt.Errorf("string to int error: %s, %s", err, b)
+	}
+	return nonce, nil
+}
+
+//校验并记录nonce, 新nonce必须大于已使用的nonce, 防止签名交易被重放
+func useNonce(stub shim.ChaincodeStubInterface, account, nonce_str string) error {
+	nonce, err := strconv.Atoi(nonce_str)
+	if err != nil {
+		return fmt.Errorf("nonce必须为整数: %s", nonce_str)
+	}
+	last, err := getNonce(stub, account)
+	if err != nil {
+		return err
+	}
+	if nonce <= last {
+		return fmt.Errorf("nonce已使用: %d", nonce)
+	}
+	return stub.PutState(account+SUFFIX_NONCE, []byte(strconv.Itoa(nonce)))
+}
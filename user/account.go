@@ -0,0 +1,160 @@
+package main
+
+import (
+	"fmt"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+	"strings"
+)
+
+/**
+账户ID为首次注册时使用的公钥, 用户数据、余额、信息和交易都以账户ID为key
+换钥后账户ID不变, 签名需使用账户当前的公钥
+*/
+const PRE_KEY_USER_KEY = "user_key"
+const PRE_KEY_ACCOUNT_KEY = "user_account_key"
+
+const OP_ROTATE_KEY = "rotateKey"
+
+//公钥绑定的账户ID, 公钥未经换钥绑定时返回空
+func getKeyAccount(stub shim.ChaincodeStubInterface, pubKey string) (string, error) {
+	key, err := stub.CreateCompositeKey(PRE_KEY_USER_KEY, []string{pubKey})
+	if err != nil {
+		return "", err
+	}
+	b, err := stub.GetState(key)
+	if err != nil {
+		return "", fmt.Errorf("系统异常")
+	}
+	return string(b), nil
+}
+
+//账户当前的公钥, 未换过钥时为账户ID本身
+func getCurrentKey(stub shim.ChaincodeStubInterface, account string) (string, error) {
+	user_str, err := stub.GetState(account)
+	if err != nil {
+		return "", fmt.Errorf("系统异常")
+	}
+	if len(user_str) == 0 {
+		return "", fmt.Errorf("用户不存在")
+	}
+	key, err := stub.CreateCompositeKey(PRE_KEY_ACCOUNT_KEY, []string{account})
+	if err != nil {
+		return "", err
+	}
+	b, err := stub.GetState(key)
+	if err != nil {
+		return "", fmt.Errorf("系统异常")
+	}
+	if len(b) == 0 {
+		return account, nil
+	}
+	return string(b), nil
+}
+
+//公钥对应的账户ID, 只接受账户当前的公钥
+func resolveKey(stub shim.ChaincodeStubInterface, pubKey string) (string, error) {
+	account, err := getKeyAccount(stub, pubKey)
+	if err != nil {
+		return "", err
+	}
+	if len(account) == 0 {
+		account = pubKey
+	}
+	current, err := getCurrentKey(stub, account)
+	if err != nil {
+		return "", err
+	}
+	if current != pubKey {
+		return "", fmt.Errorf("公钥已失效")
+	}
+	return account, nil
+}
+
+//公钥是否已被使用过, 用过的公钥不能再绑定, 防止旧签名被重放
+func isKeyUsed(stub shim.ChaincodeStubInterface, pubKey string) (bool, error) {
+	user_str, err := stub.GetState(pubKey)
+	if err != nil {
+		return false, fmt.Errorf("系统异常")
+	}
+	if len(user_str) > 0 {
+		return true, nil
+	}
+	account, err := getKeyAccount(stub, pubKey)
+	if err != nil {
+		return false, err
+	}
+	return len(account) > 0, nil
+}
+
+/**
+换钥, 新旧公钥都需对 rotateKey|oldKey|newKey|nonce 签名, nonce为账户的nonce
+旧公钥换钥后失效, 新公钥必须从未使用过
+*/
+func (t *UserChaincode) rotateKey(stub shim.ChaincodeStubInterface, oldKey, newKey, nonce, sig_old, sig_new string) pb.Response {
+	if len(newKey) == 0 || newKey == oldKey {
+		return shim.Error("newKey参数异常")
+	}
+	account, err := resolveKey(stub, oldKey)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	used, err := isKeyUsed(stub, newKey)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if used {
+		return shim.Error("新公钥已被使用")
+	}
+	//签名信息校验
+	payload := strings.Join([]string{OP_ROTATE_KEY, oldKey, newKey, nonce}, "|")
+	if !Verify(oldKey, payload, sig_old) || !Verify(newKey, payload, sig_new) {
+		return shim.Error("签名验证失败")
+	}
+	//防止重放
+	if err = useNonce(stub, account, nonce); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	newKeyKey, err := stub.CreateCompositeKey(PRE_KEY_USER_KEY, []string{newKey})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	oldKeyKey, err := stub.CreateCompositeKey(PRE_KEY_USER_KEY, []string{oldKey})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	accountKey, err := stub.CreateCompositeKey(PRE_KEY_ACCOUNT_KEY, []string{account})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	//旧公钥保留绑定关系, 用于判断公钥已被使用
+	if err = stub.PutState(oldKeyKey, []byte(account)); err != nil {
+		return shim.Error("写入数据失败")
+	}
+	if err = stub.PutState(newKeyKey, []byte(account)); err != nil {
+		return shim.Error("写入数据失败")
+	}
+	if err = stub.PutState(accountKey, []byte(newKey)); err != nil {
+		return shim.Error("写入数据失败")
+	}
+	return shim.Success([]byte(account))
+}
+
+//查询公钥对应的账户ID, 已失效的公钥报错
+func (t *UserChaincode) resolve(stub shim.ChaincodeStubInterface, pubKey string) pb.Response {
+	account, err := resolveKey(stub, pubKey)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success([]byte(account))
+}
+
+//查询账户当前的公钥, 其他合约用于校验签名
+func (t *UserChaincode) getKey(stub shim.ChaincodeStubInterface, account string) pb.Response {
+	key, err := getCurrentKey(stub, account)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success([]byte(key))
+}
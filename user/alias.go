@@ -0,0 +1,262 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+	"strings"
+)
+
+//昵称唯一索引, 公开账本, 不区分大小写
+const PRE_KEY_NICKNAME = "user_nickname"
+
+/**
+手机号唯一索引, 保存在个人信息私有数据集合中
+私有数据的key哈希会上公开账本, 所以索引key使用带密钥的HMAC, 防止按手机号穷举
+*/
+const PRE_KEY_PHONE = "user_phone"
+
+/**
+索引密钥, 保存在个人信息私有数据集合中, 通过transient的secret字段在Init或setIndexSecret中设置一次
+未设置时不建立手机号索引, 注册和修改资料不受影响
+*/
+const KEY_INDEX_SECRET = "user_index_secret"
+const TRANSIENT_SECRET = "secret"
+const MIN_SECRET_LEN = 32
+
+//transient中个人信息需要用到的字段
+type PII struct {
+	Phonenum  string
+	CompanyID string
+}
+
+func nicknameKey(stub shim.ChaincodeStubInterface, nickname string) (string, error) {
+	return stub.CreateCompositeKey(PRE_KEY_NICKNAME, []string{strings.ToLower(strings.TrimSpace(nickname))})
+}
+
+func phoneKey(stub shim.ChaincodeStubInterface, secret []byte, phone string) (string, error) {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(strings.TrimSpace(phone)))
+	return stub.CreateCompositeKey(PRE_KEY_PHONE, []string{hex.EncodeToString(mac.Sum(nil))})
+}
+
+//保存索引密钥, 设置后不能修改, 否则已有索引失效
+func putIndexSecret(stub shim.ChaincodeStubInterface, secret []byte) error {
+	old, err := stub.GetPrivateData(COLLECTION_PII, KEY_INDEX_SECRET)
+	if err != nil {
+		return fmt.Errorf("系统异常")
+	}
+	if len(old) > 0 {
+		return fmt.Errorf("索引密钥已设置")
+	}
+	if len(secret) < MIN_SECRET_LEN {
+		return fmt.Errorf("secret至少%d字节", MIN_SECRET_LEN)
+	}
+	if err = stub.PutPrivateData(COLLECTION_PII, KEY_INDEX_SECRET, secret); err != nil {
+		return fmt.Errorf("写入数据失败")
+	}
+	return nil
+}
+
+//设置手机号索引密钥, 只允许管理员操作
+func (t *UserChaincode) setIndexSecret(stub shim.ChaincodeStubInterface) pb.Response {
+	if !isAdmin(stub) {
+		return shim.Error("没有管理员权限")
+	}
+	transient, err := stub.GetTransient()
+	if err != nil {
+		return shim.Error("get transient error")
+	}
+	if err = putIndexSecret(stub, transient[TRANSIENT_SECRET]); err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success([]byte("ok"))
+}
+
+//修改昵称索引, 新昵称已被其他账户使用时报错
+func updateNickname(stub shim.ChaincodeStubInterface, account, oldNickname, newNickname string) error {
+	oldKey, err := nicknameKey(stub, oldNickname)
+	if err != nil {
+		return err
+	}
+	newKey, err := nicknameKey(stub, newNickname)
+	if err != nil {
+		return err
+	}
+	owner, err := stub.GetState(newKey)
+	if err != nil {
+		return fmt.Errorf("系统异常")
+	}
+	if len(owner) > 0 && string(owner) != account {
+		return fmt.Errorf("昵称已被使用: %s", newNickname)
+	}
+	//旧昵称索引属于本账户时才删除, 旧数据可能没有建立索引
+	if len(oldNickname) > 0 && oldKey != newKey {
+		oldOwner, err := stub.GetState(oldKey)
+		if err != nil {
+			return fmt.Errorf("系统异常")
+		}
+		if string(oldOwner) == account {
+			if err = stub.DelState(oldKey); err != nil {
+				return fmt.Errorf("写入数据失败")
+			}
+		}
+	}
+	if len(owner) == 0 {
+		if err = stub.PutState(newKey, []byte(account)); err != nil {
+			return fmt.Errorf("写入数据失败")
+		}
+	}
+	return nil
+}
+
+//修改手机号索引, 新手机号已被其他账户使用时报错
+//索引密钥未设置时跳过, 设置前登记的手机号不在索引中
+func updatePhone(stub shim.ChaincodeStubInterface, account string, old_pii, new_pii []byte) error {
+	secret, err := stub.GetPrivateData(COLLECTION_PII, KEY_INDEX_SECRET)
+	if err != nil {
+		return fmt.Errorf("系统异常")
+	}
+	if len(secret) == 0 {
+		return nil
+	}
+	var oldPII, newPII PII
+	if len(old_pii) > 0 {
+		if err := json.Unmarshal(old_pii, &oldPII); err != nil {
+			return fmt.Errorf("pii json error: %s", err)
+		}
+	}
+	if err := json.Unmarshal(new_pii, &newPII); err != nil {
+		return fmt.Errorf("pii json error: %s", err)
+	}
+	oldKey, err := phoneKey(stub, secret, oldPII.Phonenum)
+	if err != nil {
+		return err
+	}
+	newKey, err := phoneKey(stub, secret, newPII.Phonenum)
+	if err != nil {
+		return err
+	}
+	owner, err := stub.GetPrivateData(COLLECTION_PII, newKey)
+	if err != nil {
+		return fmt.Errorf("系统异常")
+	}
+	if len(owner) > 0 && string(owner) != account {
+		return fmt.Errorf("手机号已被使用")
+	}
+	if len(oldPII.Phonenum) > 0 && oldKey != newKey {
+		oldOwner, err := stub.GetPrivateData(COLLECTION_PII, oldKey)
+		if err != nil {
+			return fmt.Errorf("系统异常")
+		}
+		if string(oldOwner) == account {
+			if err = stub.DelPrivateData(COLLECTION_PII, oldKey); err != nil {
+				return fmt.Errorf("写入数据失败")
+			}
+		}
+	}
+	if len(owner) == 0 {
+		if err = stub.PutPrivateData(COLLECTION_PII, newKey, []byte(account)); err != nil {
+			return fmt.Errorf("写入数据失败")
+		}
+	}
+	return nil
+}
+
+//补建昵称索引的结果, NextKey为空表示已处理完
+type NicknameIndexResult struct {
+	Indexed   int
+	Conflicts []string
+	NextKey   string
+}
+
+/**
+为升级前注册的用户补建昵称索引, 只允许管理员操作
+从startKey开始最多处理limit个用户, 昵称重复时先处理的账户优先, 冲突的账户需修改昵称
+分页查询只能在只读交易中使用, 所以按key范围分批处理
+*/
+func (t *UserChaincode) indexNicknames(stub shim.ChaincodeStubInterface, startKey string, limit int) pb.Response {
+	if !isAdmin(stub) {
+		return shim.Error("没有管理员权限")
+	}
+	if limit <= 0 {
+		return shim.Error("limit must be positive")
+	}
+	rs, err := stub.GetStateByRange(startKey, "")
+	if err != nil {
+		return shim.Error("系统异常")
+	}
+	defer rs.Close()
+
+	result := &NicknameIndexResult{Conflicts: []string{}}
+	//同一交易内读不到本次写入, 记录本批已建立的索引
+	claimed := make(map[string]string)
+	count := 0
+	for rs.HasNext() {
+		kv, err := rs.Next()
+		if err != nil {
+			return shim.Error(fmt.Sprintf("find error: %s", err))
+		}
+		if count >= limit {
+			result.NextKey = kv.Key
+			break
+		}
+		if kv.Key == KEY_ADMIN_MSP || strings.HasSuffix(kv.Key, SUFFIX_NONCE) {
+			continue
+		}
+		var u User
+		if err = json.Unmarshal(kv.Value, &u); err != nil || len(u.Nickname) == 0 {
+			continue
+		}
+		count++
+		key, err := nicknameKey(stub, u.Nickname)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		owner, ok := claimed[key]
+		if !ok {
+			b, err := stub.GetState(key)
+			if err != nil {
+				return shim.Error("系统异常")
+			}
+			owner = string(b)
+		}
+		if owner == kv.Key {
+			continue
+		}
+		if len(owner) > 0 {
+			result.Conflicts = append(result.Conflicts, kv.Key)
+			continue
+		}
+		if err = stub.PutState(key, []byte(kv.Key)); err != nil {
+			return shim.Error("写入数据失败")
+		}
+		claimed[key] = kv.Key
+		result.Indexed++
+	}
+	data, err := json.Marshal(result)
+	if err != nil {
+		return shim.Error("json error")
+	}
+	return shim.Success(data)
+}
+
+//按昵称查询账户ID
+func (t *UserChaincode) getByNickname(stub shim.ChaincodeStubInterface, nickname string) pb.Response {
+	key, err := nicknameKey(stub, nickname)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	account, err := stub.GetState(key)
+	if err != nil {
+		return shim.Error("系统异常")
+	}
+	if len(account) == 0 {
+		return shim.Error("用户不存在")
+	}
+	return shim.Success(account)
+}
@@ -1,22 +1,97 @@
 package main
 
 import (
+	"encoding/json"
 	"fmt"
 	"github.com/hyperledger/fabric/core/chaincode/shim"
 	pb "github.com/hyperledger/fabric/protos/peer"
+	"strconv"
+	"strings"
+	"time"
 )
 
 const INIT_COIN = "100"
 
-//peer chaincode invoke -C mychannel -n user -c '{"Function":"set","Args":["pubkey","{\"Name\":\"Yan\",\"Age\":\"18\",\"ID\":\"43070212345767\",\"Nickname\":\"swf\",\"Phonenum\":\"1376890876\"}","sign"]}'
+//个人信息私有数据集合, 配置见collections_config.json
+const COLLECTION_PII = "collectionUserPII"
+const TRANSIENT_PII = "pii"
+
+//peer chaincode invoke -C mychannel -n user -c '{"Function":"set","Args":["pubkey","{\"Nickname\":\"swf\"}","1","sign"]}' --transient "{\"pii\":\"$(echo -n '{"Name":"Yan","Age":"18","ID":"43070212345767","Phonenum":"1376890876","Salt":"a8f3c1d92b7e4f60"}' | base64 -w 0)\"}"
 //peer chaincode invoke -C mychannel -n user -c '{"Function":"get","Args":["pubkey"]}'
+//peer chaincode invoke -C mychannel -n user -c '{"Function":"patch","Args":["pubkey","{\"Nickname\":\"swf2\"}","2","sign"]}'
+//peer chaincode query -C mychannel -n user -c '{"Function":"getUserHistory","Args":["pubkey"]}'
+//peer chaincode invoke -C mychannel -n user -c '{"Function":"applyMerchant","Args":["pubkey2","2","sign"]}'
+//peer chaincode invoke -C mychannel -n user -c '{"Function":"setMerchantState","Args":["pubkey2","2"]}'
+//peer chaincode invoke -C mychannel -n user -c '{"Function":"suspend","Args":["pubkey2","fraud report #12"]}'
+//peer chaincode query -C mychannel -n user -c '{"Function":"getByNickname","Args":["swf"]}'
+//peer chaincode upgrade -C mychannel -n user -v 2.0 -c '{"Args":["init","Org1MSP"]}' --collections-config collections_config.json --transient "{\"secret\":\"$(head -c 32 /dev/urandom | base64 -w 0)\"}"
+//peer chaincode invoke -C mychannel -n user -c '{"Function":"setIndexSecret","Args":[]}' --transient "{\"secret\":\"$(head -c 32 /dev/urandom | base64 -w 0)\"}"
+//peer chaincode invoke -C mychannel -n user -c '{"Function":"indexNicknames","Args":["","100"]}'
+//peer chaincode invoke -C mychannel -n user -c '{"Function":"rotateKey","Args":["oldPubkey","newPubkey","3","signOld","signNew"]}'
+
+//peer chaincode invoke -C mychannel -n user -c '{"Function":"set","Args":["pubkey2","{\"Nickname\":\"swf\",\"CompanyName\":\"58Company\"}","1","sign"]}' --transient "{\"pii\":\"$(echo -n '{"Name":"Yan","Age":"18","ID":"43070212345767","Phonenum":"1376890876","CompanyID":"5858","Salt":"a8f3c1d92b7e4f60"}' | base64 -w 0)\"}"
+
+//公开的用户信息, 个人信息保存在私有数据集合中, 链上只保存加盐哈希
+//每次修改Version加1
+type User struct {
+	Nickname    string
+	CompanyName string
+	PIIHash     string
+	Version     int
+}
+
+//用户信息的一次修改记录
+type UserHistory struct {
+	TxID      string
+	Timestamp time.Time
+	IsDelete  bool
+	Value     json.RawMessage
+}
+
+/**
+首次注册赠币事件
+coin合约经链码间调用产生的事件不会发送给客户端, 由user合约发送
+*/
+type UserEvent struct {
+	Account  string
+	Function string
+	Op       string
+	Amount   string
+	TxID     string
+}
+
+func emitUserEvent(stub shim.ChaincodeStubInterface, function, account, op, amount string) error {
+	e := &UserEvent{Account: account, Function: function, Op: op, Amount: amount, TxID: stub.GetTxID()}
+	payload, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	return stub.SetEvent("user."+function, payload)
+}
 
-//peer chaincode invoke -C mychannel -n user -c '{"Function":"set","Args":["pubkey2","{\"Name\":\"Yan\",\"ID\":\"43070212345767\",\"Age\":\"18\",\"Nickname\":\"swf\",\"Phonenum\":\"1376890876\",\"CompanyName\":\"58Company\",\"CompanyID\":\"5858\"}","sign"]}'
 type UserChaincode struct {
 }
 
+//初始化参数: [管理员MSP], 不传时保留原有配置
+//升级时可通过transient的secret字段设置手机号索引密钥
 func (t *UserChaincode) Init(stub shim.ChaincodeStubInterface) pb.Response {
 	fmt.Println("UserChaincode Init")
+	_, args := stub.GetFunctionAndParameters()
+	if len(args) > 0 && len(args[0]) > 0 {
+		err := stub.PutState(KEY_ADMIN_MSP, []byte(args[0]))
+		if err != nil {
+			return shim.Error("写入数据失败")
+		}
+	}
+	transient, err := stub.GetTransient()
+	if err != nil {
+		return shim.Error("get transient error")
+	}
+	if secret, ok := transient[TRANSIENT_SECRET]; ok {
+		if err = putIndexSecret(stub, secret); err != nil {
+			return shim.Error(err.Error())
+		}
+	}
 	return shim.Success([]byte("success init"))
 }
 
@@ -28,18 +103,141 @@ func (t *UserChaincode) Invoke(stub shim.ChaincodeStubInterface) pb.Response {
 		}
 		return t.get(stub, args[0])
 	} else if function == "set" {
+		if len(args) != 4 {
+			return shim.Error("Incorrect num of args, excepting 4")
+		}
+		return t.set(stub, args[0], args[1], args[2], args[3])
+	} else if function == "getNonce" {
+		if len(args) != 1 {
+			return shim.Error("Incorrect num of args, excepting 1")
+		}
+		nonce, err := getNonce(stub, args[0])
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		return shim.Success([]byte(strconv.Itoa(nonce)))
+	} else if function == "patch" {
+		if len(args) != 4 {
+			return shim.Error("Incorrect num of args, excepting 4")
+		}
+		return t.patch(stub, args[0], args[1], args[2], args[3])
+	} else if function == "getUserHistory" {
+		if len(args) != 1 {
+			return shim.Error("Incorrect num of args, excepting 1")
+		}
+		return t.getUserHistory(stub, args[0])
+	} else if function == "applyMerchant" {
 		if len(args) != 3 {
 			return shim.Error("Incorrect num of args, excepting 3")
 		}
-		return t.set(stub, args[0], args[1], args[2])
+		return t.applyMerchant(stub, args[0], args[1], args[2])
+	} else if function == "setMerchantState" {
+		if len(args) != 2 && len(args) != 3 {
+			return shim.Error("Incorrect num of args, excepting 2 or 3")
+		}
+		state, err := strconv.Atoi(args[1])
+		if err != nil {
+			return shim.Error("参数转换为int类型异常")
+		}
+		reason := ""
+		if len(args) == 3 {
+			reason = args[2]
+		}
+		return t.setMerchantState(stub, args[0], state, reason)
+	} else if function == "getMerchant" {
+		if len(args) != 1 {
+			return shim.Error("Incorrect num of args, excepting 1")
+		}
+		return t.getMerchant(stub, args[0])
+	} else if function == "checkMerchant" {
+		if len(args) != 1 {
+			return shim.Error("Incorrect num of args, excepting 1")
+		}
+		return t.checkMerchant(stub, args[0])
+	} else if function == "suspend" {
+		if len(args) != 2 {
+			return shim.Error("Incorrect num of args, excepting 2")
+		}
+		return t.suspend(stub, args[0], args[1])
+	} else if function == "reinstate" {
+		if len(args) != 1 {
+			return shim.Error("Incorrect num of args, excepting 1")
+		}
+		return t.reinstate(stub, args[0])
+	} else if function == "getSuspension" {
+		if len(args) != 1 {
+			return shim.Error("Incorrect num of args, excepting 1")
+		}
+		return t.getSuspension(stub, args[0])
+	} else if function == "checkActive" {
+		if len(args) != 1 {
+			return shim.Error("Incorrect num of args, excepting 1")
+		}
+		return t.checkActive(stub, args[0])
+	} else if function == "setIndexSecret" {
+		return t.setIndexSecret(stub)
+	} else if function == "indexNicknames" {
+		if len(args) != 2 {
+			return shim.Error("Incorrect num of args, excepting 2")
+		}
+		limit, err := strconv.Atoi(args[1])
+		if err != nil {
+			return shim.Error("参数转换为int类型异常")
+		}
+		return t.indexNicknames(stub, args[0], limit)
+	} else if function == "getByNickname" {
+		if len(args) != 1 {
+			return shim.Error("Incorrect num of args, excepting 1")
+		}
+		return t.getByNickname(stub, args[0])
+	} else if function == "getPII" {
+		if len(args) != 1 {
+			return shim.Error("Incorrect num of args, excepting 1")
+		}
+		return t.getPII(stub, args[0])
+	} else if function == "rotateKey" {
+		if len(args) != 5 {
+			return shim.Error("Incorrect num of args, excepting 5")
+		}
+		return t.rotateKey(stub, args[0], args[1], args[2], args[3], args[4])
+	} else if function == "resolve" {
+		if len(args) != 1 {
+			return shim.Error("Incorrect num of args, excepting 1")
+		}
+		return t.resolve(stub, args[0])
+	} else if function == "getKey" {
+		if len(args) != 1 {
+			return shim.Error("Incorrect num of args, excepting 1")
+		}
+		return t.getKey(stub, args[0])
 	}
 	return shim.Success([]byte("error fuction"))
 }
 
-func (t *UserChaincode) set(stub shim.ChaincodeStubInterface, pubKey string, user_str string, sign string) pb.Response {
-	//签名信息校验
-	if !Verify(pubKey, user_str, sign) {
-		return shim.Error("签名验证失败")
+/**
+pubKey为账户ID, 已注册的账户需用当前公钥签名
+个人信息通过transient的pii字段传入, 签名内容为 set|user_str|PIIHash|nonce
+*/
+func (t *UserChaincode) set(stub shim.ChaincodeStubInterface, pubKey string, user_str string, nonce string, sign string) pb.Response {
+	old_user, err := stub.GetState(pubKey)
+	if err != nil {
+		return shim.Error("系统异常")
+	}
+	sign_key := pubKey
+	if len(old_user) > 0 {
+		sign_key, err = getCurrentKey(stub, pubKey)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+	} else {
+		//换钥绑定过的公钥不能注册为新账户
+		account, err := getKeyAccount(stub, pubKey)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		if len(account) > 0 {
+			return shim.Error("公钥已绑定其他账户")
+		}
 	}
 
 	checkResponse := stub.InvokeChaincode("check_user_gr", [][]byte{[]byte("check"), []byte(user_str)}, "")
@@ -47,31 +245,190 @@ func (t *UserChaincode) set(stub shim.ChaincodeStubInterface, pubKey string, use
 	if checkResponse.GetStatus() != shim.OK {
 		return checkResponse
 	}
-
-	old_user, err := stub.GetState(pubKey)
+	var u User
+	if err = json.Unmarshal(checkResponse.GetPayload(), &u); err != nil {
+		return shim.Error("用户数据异常")
+	}
+	//签名信息校验
+	if !Verify(sign_key, strings.Join([]string{"set", user_str, u.PIIHash, nonce}, "|"), sign) {
+		return shim.Error("签名验证失败")
+	}
+	//防止重放
+	if err = useNonce(stub, pubKey, nonce); err != nil {
+		return shim.Error(err.Error())
+	}
+	transient, err := stub.GetTransient()
+	if err != nil {
+		return shim.Error("get transient error")
+	}
+	old_pii, err := stub.GetPrivateData(COLLECTION_PII, pubKey)
 	if err != nil {
 		return shim.Error("系统异常")
 	}
+	//手机号不能与其他账户重复
+	if err = updatePhone(stub, pubKey, old_pii, transient[TRANSIENT_PII]); err != nil {
+		return shim.Error(err.Error())
+	}
+	companyChanged, err := isCompanyIDChanged(old_pii, transient[TRANSIENT_PII])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = stub.PutPrivateData(COLLECTION_PII, pubKey, transient[TRANSIENT_PII])
+	if err != nil {
+		return shim.Error("写入数据失败")
+	}
+
 	//第一次注册赠送100个币
-	if old_user == nil || len(old_user) == 0 {
+	is_new := old_user == nil || len(old_user) == 0
+	if is_new {
 		issueResponse := stub.InvokeChaincode("coin", [][]byte{[]byte("issue"), []byte(pubKey), []byte(INIT_COIN)}, "")
 		if issueResponse.GetStatus() != shim.OK {
 			return issueResponse
 		}
 	}
-	err = stub.PutState(pubKey, checkResponse.GetPayload())
-	if err != nil {
-		return shim.Error("写入数据失败")
+	if err = putUser(stub, pubKey, &u, old_user, companyChanged); err != nil {
+		return shim.Error(err.Error())
+	}
+	if is_new {
+		if err = emitUserEvent(stub, "set", pubKey, "issue", INIT_COIN); err != nil {
+			return shim.Error("set event fail")
+		}
 	}
 	return shim.Success([]byte("ok"))
 }
 
+/**
+部分更新公开信息, patch_str为需修改的字段, 如{"Nickname":"swf"}
+签名内容为 patch|patch_str|nonce
+*/
+func (t *UserChaincode) patch(stub shim.ChaincodeStubInterface, pubKey, patch_str, nonce, sign string) pb.Response {
+	sign_key, err := getCurrentKey(stub, pubKey)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	//签名信息校验
+	if !Verify(sign_key, strings.Join([]string{"patch", patch_str, nonce}, "|"), sign) {
+		return shim.Error("签名验证失败")
+	}
+	//防止重放
+	if err = useNonce(stub, pubKey, nonce); err != nil {
+		return shim.Error(err.Error())
+	}
+	old_user, err := stub.GetState(pubKey)
+	if err != nil {
+		return shim.Error("系统异常")
+	}
+	checkResponse := stub.InvokeChaincode("check_user_gr", [][]byte{[]byte("checkPatch"), old_user, []byte(patch_str)}, "")
+	//用户信息校验
+	if checkResponse.GetStatus() != shim.OK {
+		return checkResponse
+	}
+	var u User
+	if err = json.Unmarshal(checkResponse.GetPayload(), &u); err != nil {
+		return shim.Error("用户数据异常")
+	}
+	if err = putUser(stub, pubKey, &u, old_user, false); err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(u.toString())
+}
+
+/**
+写入用户信息, 版本号在原记录基础上加1, 昵称不能与其他账户重复
+商家信息变更后需重新审核
+*/
+func putUser(stub shim.ChaincodeStubInterface, pubKey string, u *User, old_user []byte, companyChanged bool) error {
+	var old User
+	if len(old_user) > 0 {
+		if err := json.Unmarshal(old_user, &old); err != nil {
+			return fmt.Errorf("用户数据异常")
+		}
+	}
+	u.Version = old.Version + 1
+	if err := updateNickname(stub, pubKey, old.Nickname, u.Nickname); err != nil {
+		return err
+	}
+	if len(old_user) > 0 && (companyChanged || old.CompanyName != u.CompanyName) {
+		if err := resetMerchant(stub, pubKey, u.CompanyName); err != nil {
+			return err
+		}
+	}
+	if err := stub.PutState(pubKey, u.toString()); err != nil {
+		return fmt.Errorf("写入数据失败")
+	}
+	return nil
+}
+
+//查询用户信息的修改历史, 只包含公开字段
+func (t *UserChaincode) getUserHistory(stub shim.ChaincodeStubInterface, pubKey string) pb.Response {
+	rs, err := stub.GetHistoryForKey(pubKey)
+	if err != nil {
+		return shim.Error("系统异常")
+	}
+	defer rs.Close()
+
+	histories := []UserHistory{}
+	for rs.HasNext() {
+		modification, err := rs.Next()
+		if err != nil {
+			error_str := fmt.Sprintf("find error: %s", err)
+			fmt.Println(error_str)
+			return shim.Error(error_str)
+		}
+		h := UserHistory{TxID: modification.TxId, IsDelete: modification.IsDelete}
+		if modification.Timestamp != nil {
+			h.Timestamp = time.Unix(modification.Timestamp.Seconds, int64(modification.Timestamp.Nanos))
+		}
+		//旧版本记录中含明文个人信息, 只返回公开字段
+		if len(modification.Value) > 0 {
+			var u User
+			if err = json.Unmarshal(modification.Value, &u); err != nil {
+				return shim.Error("用户数据异常")
+			}
+			h.Value = u.toString()
+		}
+		histories = append(histories, h)
+	}
+	json_histories, err := json.Marshal(histories)
+	if err != nil {
+		return shim.Error("json error")
+	}
+	return shim.Success(json_histories)
+}
+
+func (u *User) toString() []byte {
+	if data, err := json.Marshal(u); err == nil {
+		return data
+	}
+	return []byte("err")
+}
+
+//查询个人信息, 只允许管理员在集合成员节点上查询
+func (t *UserChaincode) getPII(stub shim.ChaincodeStubInterface, pubKey string) pb.Response {
+	if !isAdmin(stub) {
+		return shim.Error("没有管理员权限")
+	}
+	pii, err := stub.GetPrivateData(COLLECTION_PII, pubKey)
+	if err != nil {
+		return shim.Error("系统异常")
+	}
+	return shim.Success(pii)
+}
+
 func (t *UserChaincode) get(stub shim.ChaincodeStubInterface, pubKey string) pb.Response {
 	user_str, err := stub.GetState(pubKey)
 	if err != nil {
 		return shim.Error("系统异常")
 	}
-	return shim.Success(user_str)
+	if len(user_str) == 0 {
+		return shim.Success(user_str)
+	}
+	//旧版本记录中含明文个人信息, 只返回公开字段
+	var u User
+	if err = json.Unmarshal(user_str, &u); err != nil {
+		return shim.Error("用户数据异常")
+	}
+	return shim.Success(u.toString())
 }
 
 func main() {
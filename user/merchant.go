@@ -0,0 +1,236 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+	"strings"
+	"time"
+)
+
+const PRE_KEY_MERCHANT = "user_merchant"
+
+//商家申请状态
+const MERCHANT_APPLIED = 1
+const MERCHANT_REVIEWING = 2
+const MERCHANT_APPROVED = 3
+const MERCHANT_REJECTED = 4
+const MERCHANT_SUSPENDED = 5
+
+//管理员可以进行的状态变更, 被驳回后用户可以重新申请
+var merchantTransitions = map[int][]int{
+	MERCHANT_APPLIED:   {MERCHANT_REVIEWING, MERCHANT_REJECTED},
+	MERCHANT_REVIEWING: {MERCHANT_APPROVED, MERCHANT_REJECTED},
+	MERCHANT_APPROVED:  {MERCHANT_SUSPENDED},
+	MERCHANT_SUSPENDED: {MERCHANT_APPROVED},
+}
+
+//商家申请, 以账户ID为key
+type Merchant struct {
+	Account     string
+	CompanyName string
+	State       int
+	Reason      string
+	ApplyTime   time.Time
+	UpdateTime  time.Time
+}
+
+func (m *Merchant) toString() []byte {
+	if data, err := json.Marshal(m); err == nil {
+		return data
+	}
+	return []byte("err")
+}
+
+//读取商家申请, 不存在时返回nil
+func getMerchant(stub shim.ChaincodeStubInterface, account string) (*Merchant, error) {
+	key, err := stub.CreateCompositeKey(PRE_KEY_MERCHANT, []string{account})
+	if err != nil {
+		return nil, err
+	}
+	b, err := stub.GetState(key)
+	if err != nil {
+		return nil, fmt.Errorf("系统异常")
+	}
+	if len(b) == 0 {
+		return nil, nil
+	}
+	var m Merchant
+	err = json.Unmarshal(b, &m)
+	if err != nil {
+		return nil, fmt.Errorf("merchant json error: %s", err)
+	}
+	return &m, nil
+}
+
+func putMerchant(stub shim.ChaincodeStubInterface, m *Merchant) error {
+	key, err := stub.CreateCompositeKey(PRE_KEY_MERCHANT, []string{m.Account})
+	if err != nil {
+		return err
+	}
+	return stub.PutState(key, m.toString())
+}
+
+//读取用户公开信息
+func getUser(stub shim.ChaincodeStubInterface, account string) (*User, error) {
+	user_str, err := stub.GetState(account)
+	if err != nil {
+		return nil, fmt.Errorf("系统异常")
+	}
+	if len(user_str) == 0 {
+		return nil, fmt.Errorf("用户不存在")
+	}
+	var u User
+	if err = json.Unmarshal(user_str, &u); err != nil {
+		return nil, fmt.Errorf("用户数据异常")
+	}
+	return &u, nil
+}
+
+/**
+申请成为商家, 需先通过set提交CompanyName及CompanyID
+签名内容为 applyMerchant|nonce
+*/
+func (t *UserChaincode) applyMerchant(stub shim.ChaincodeStubInterface, pubKey, nonce, sign string) pb.Response {
+	sign_key, err := getCurrentKey(stub, pubKey)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	//签名信息校验
+	if !Verify(sign_key, strings.Join([]string{"applyMerchant", nonce}, "|"), sign) {
+		return shim.Error("签名验证失败")
+	}
+	//防止重放
+	if err = useNonce(stub, pubKey, nonce); err != nil {
+		return shim.Error(err.Error())
+	}
+	u, err := getUser(stub, pubKey)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if len(u.CompanyName) == 0 {
+		return shim.Error("商家信息不完整,CompanyID、CompanyName必须填写")
+	}
+	m, err := getMerchant(stub, pubKey)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if m != nil && m.State != MERCHANT_REJECTED {
+		return shim.Error("已申请商家")
+	}
+	tm, err := stub.GetTxTimestamp()
+	if err != nil {
+		return shim.Error("get timestamp fail")
+	}
+	m = &Merchant{Account: pubKey, CompanyName: u.CompanyName, State: MERCHANT_APPLIED, ApplyTime: time.Unix(tm.Seconds, 0)}
+	m.UpdateTime = m.ApplyTime
+	if err = putMerchant(stub, m); err != nil {
+		return shim.Error("写入数据失败")
+	}
+	return shim.Success(m.toString())
+}
+
+//变更商家申请状态, 只允许管理员操作
+func (t *UserChaincode) setMerchantState(stub shim.ChaincodeStubInterface, pubKey string, state int, reason string) pb.Response {
+	if !isAdmin(stub) {
+		return shim.Error("没有管理员权限")
+	}
+	m, err := getMerchant(stub, pubKey)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if m == nil {
+		return shim.Error("merchant not find")
+	}
+	allowed := false
+	for _, s := range merchantTransitions[m.State] {
+		if s == state {
+			allowed = true
+		}
+	}
+	if !allowed {
+		return shim.Error(fmt.Sprintf("不能从状态%d变更为%d", m.State, state))
+	}
+	if (state == MERCHANT_REJECTED || state == MERCHANT_SUSPENDED) && len(reason) == 0 {
+		return shim.Error("reason必须填写")
+	}
+	tm, err := stub.GetTxTimestamp()
+	if err != nil {
+		return shim.Error("get timestamp fail")
+	}
+	m.State = state
+	m.Reason = reason
+	m.UpdateTime = time.Unix(tm.Seconds, 0)
+	if err = putMerchant(stub, m); err != nil {
+		return shim.Error("写入数据失败")
+	}
+	return shim.Success(m.toString())
+}
+
+func (t *UserChaincode) getMerchant(stub shim.ChaincodeStubInterface, pubKey string) pb.Response {
+	m, err := getMerchant(stub, pubKey)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if m == nil {
+		return shim.Error("merchant not find")
+	}
+	return shim.Success(m.toString())
+}
+
+/**
+校验是否为审核通过的商家, check_info发布信息前调用
+返回商家申请记录, 发布信息使用审核通过的CompanyName
+*/
+func (t *UserChaincode) checkMerchant(stub shim.ChaincodeStubInterface, pubKey string) pb.Response {
+	m, err := getMerchant(stub, pubKey)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if m == nil || m.State != MERCHANT_APPROVED {
+		return shim.Error("商家未通过审核")
+	}
+	return shim.Success(m.toString())
+}
+
+//个人信息中的CompanyID是否变更, 没有旧个人信息时视为变更
+func isCompanyIDChanged(old_pii, new_pii []byte) (bool, error) {
+	if len(old_pii) == 0 {
+		return true, nil
+	}
+	var oldPII, newPII PII
+	if err := json.Unmarshal(old_pii, &oldPII); err != nil {
+		return false, fmt.Errorf("pii json error: %s", err)
+	}
+	if err := json.Unmarshal(new_pii, &newPII); err != nil {
+		return false, fmt.Errorf("pii json error: %s", err)
+	}
+	return oldPII.CompanyID != newPII.CompanyID, nil
+}
+
+/**
+商家信息变更后退回申请状态, 等待管理员重新审核
+被驳回或暂停的商家保持原状态
+*/
+func resetMerchant(stub shim.ChaincodeStubInterface, account, companyName string) error {
+	m, err := getMerchant(stub, account)
+	if err != nil {
+		return err
+	}
+	if m == nil || m.State == MERCHANT_REJECTED || m.State == MERCHANT_SUSPENDED {
+		return nil
+	}
+	tm, err := stub.GetTxTimestamp()
+	if err != nil {
+		return fmt.Errorf("get timestamp fail")
+	}
+	m.CompanyName = companyName
+	m.State = MERCHANT_APPLIED
+	m.Reason = "商家信息变更"
+	m.UpdateTime = time.Unix(tm.Seconds, 0)
+	if err = putMerchant(stub, m); err != nil {
+		return fmt.Errorf("写入数据失败")
+	}
+	return nil
+}
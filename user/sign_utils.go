@@ -3,13 +3,15 @@ package main
 import (
 	"crypto/ecdsa"
 	"crypto/elliptic"
+	"crypto/sha256"
 	"encoding/base64"
 	"fmt"
 	"math/big"
 )
 
 /**
-验证签名
+验证签名, 签名内容为json原文的sha256摘要
+ecdsa只校验消息的前32字节, 直接签原文时后面的内容不受签名保护
 */
 func Verify(pubkey, json, sign string) bool {
 	curve := elliptic.P256()
@@ -36,9 +38,10 @@ func Verify(pubkey, json, sign string) bool {
 	x.SetBytes(pubkeyByte[:(keyLen / 2)])
 	y.SetBytes(pubkeyByte[(keyLen / 2):])
 	//还原为原始公钥
-	rawPubKey := ecdsa.PublicKey{curve, &x, &y}
-	//公钥、签名文件、原始数据确认签名有效性
-	if ecdsa.Verify(&rawPubKey, []byte(json), &r, &s) == false {
+	rawPubKey := ecdsa.PublicKey{Curve: curve, X: &x, Y: &y}
+	//公钥、签名文件、原始数据摘要确认签名有效性
+	digest := sha256.Sum256([]byte(json))
+	if ecdsa.Verify(&rawPubKey, digest[:], &r, &s) == false {
 		return false
 	}
 	return true
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+	"time"
+)
+
+const PRE_KEY_SUSPENSION = "user_suspension"
+
+/**
+账户停用记录, 以账户ID为key
+停用的账户不能发布信息、购买或收款, 解除后保留最近一次停用原因
+*/
+type Suspension struct {
+	Account       string
+	Suspended     bool
+	Reason        string
+	SuspendTime   time.Time
+	ReinstateTime time.Time
+}
+
+func (s *Suspension) toString() []byte {
+	if data, err := json.Marshal(s); err == nil {
+		return data
+	}
+	return []byte("err")
+}
+
+//读取停用记录, 从未停用过时返回nil
+func getSuspension(stub shim.ChaincodeStubInterface, account string) (*Suspension, error) {
+	key, err := stub.CreateCompositeKey(PRE_KEY_SUSPENSION, []string{account})
+	if err != nil {
+		return nil, err
+	}
+	b, err := stub.GetState(key)
+	if err != nil {
+		return nil, fmt.Errorf("系统异常")
+	}
+	if len(b) == 0 {
+		return nil, nil
+	}
+	var s Suspension
+	err = json.Unmarshal(b, &s)
+	if err != nil {
+		return nil, fmt.Errorf("suspension json error: %s", err)
+	}
+	return &s, nil
+}
+
+func putSuspension(stub shim.ChaincodeStubInterface, s *Suspension) error {
+	key, err := stub.CreateCompositeKey(PRE_KEY_SUSPENSION, []string{s.Account})
+	if err != nil {
+		return err
+	}
+	return stub.PutState(key, s.toString())
+}
+
+//停用账户, 只允许管理员操作
+func (t *UserChaincode) suspend(stub shim.ChaincodeStubInterface, pubKey, reason string) pb.Response {
+	if !isAdmin(stub) {
+		return shim.Error("没有管理员权限")
+	}
+	if len(reason) == 0 {
+		return shim.Error("reason必须填写")
+	}
+	if _, err := getUser(stub, pubKey); err != nil {
+		return shim.Error(err.Error())
+	}
+	s, err := getSuspension(stub, pubKey)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if s != nil && s.Suspended {
+		return shim.Error("账户已停用")
+	}
+	tm, err := stub.GetTxTimestamp()
+	if err != nil {
+		return shim.Error("get timestamp fail")
+	}
+	s = &Suspension{Account: pubKey, Suspended: true, Reason: reason, SuspendTime: time.Unix(tm.Seconds, 0)}
+	if err = putSuspension(stub, s); err != nil {
+		return shim.Error("写入数据失败")
+	}
+	return shim.Success(s.toString())
+}
+
+//恢复账户, 只允许管理员操作
+func (t *UserChaincode) reinstate(stub shim.ChaincodeStubInterface, pubKey string) pb.Response {
+	if !isAdmin(stub) {
+		return shim.Error("没有管理员权限")
+	}
+	s, err := getSuspension(stub, pubKey)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if s == nil || !s.Suspended {
+		return shim.Error("账户未停用")
+	}
+	tm, err := stub.GetTxTimestamp()
+	if err != nil {
+		return shim.Error("get timestamp fail")
+	}
+	s.Suspended = false
+	s.ReinstateTime = time.Unix(tm.Seconds, 0)
+	if err = putSuspension(stub, s); err != nil {
+		return shim.Error("写入数据失败")
+	}
+	return shim.Success(s.toString())
+}
+
+//查询停用记录, 从未停用过时返回空
+func (t *UserChaincode) getSuspension(stub shim.ChaincodeStubInterface, pubKey string) pb.Response {
+	s, err := getSuspension(stub, pubKey)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if s == nil {
+		return shim.Success(nil)
+	}
+	return shim.Success(s.toString())
+}
+
+//校验账户存在且未停用, coin、check_info、trade在资金变动和发布信息前调用
+func (t *UserChaincode) checkActive(stub shim.ChaincodeStubInterface, pubKey string) pb.Response {
+	if _, err := getUser(stub, pubKey); err != nil {
+		return shim.Error(err.Error())
+	}
+	s, err := getSuspension(stub, pubKey)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if s != nil && s.Suspended {
+		return shim.Error(fmt.Sprintf("账户已停用: %s", s.Reason))
+	}
+	return shim.Success([]byte("ok"))
+}